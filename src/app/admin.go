@@ -0,0 +1,217 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Статусы завершения сообщений, сохраняемые в истории
+const (
+	historyCompleted = "completed" // Сообщение полностью собрано
+	historyTimeout   = "timeout"   // Истек таймаут сборки
+	historyForced    = "forced"    // Принудительно завершено администратором
+	historyExpired   = "expired"   // Принудительно помечено как просроченное администратором
+	historyDropped   = "dropped"   // Удалено администратором без уведомления прикладного уровня
+	historyEvicted   = "evicted"   // Вытеснено из-за превышения лимитов памяти
+)
+
+// Описание незавершенного сообщения для admin API
+type InFlightInfo struct {
+	Key              string    `json:"key"`
+	Sender           string    `json:"sender"`
+	SendTime         time.Time `json:"send_time"`
+	TotalSegments    int       `json:"total_segments"`
+	PayloadBytes     int       `json:"payload_bytes"`
+	ReceivedSegments []int     `json:"received_segments"`
+	MissingSegments  []int     `json:"missing_segments"`
+	FirstArrival     time.Time `json:"first_arrival"`
+	LastArrival      time.Time `json:"last_arrival"`
+	Age              string    `json:"age"`  // Время с момента поступления первого сегмента
+	Idle             string    `json:"idle"` // Время с момента поступления последнего сегмента
+}
+
+// Запись истории о собранном или несобранном сообщении
+type HistoryEntry struct {
+	Key              string    `json:"key"`
+	Sender           string    `json:"sender"`
+	SendTime         time.Time `json:"send_time"`
+	Status           string    `json:"status"`
+	TotalSegments    int       `json:"total_segments"`
+	ReceivedSegments int       `json:"received_segments"`
+	FinishedAt       time.Time `json:"finished_at"`
+	ErrorMsg         string    `json:"error_msg,omitempty"`
+}
+
+// historyRing - Кольцевой буфер последних завершенных сообщений ограниченного размера
+type historyRing struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	next    int
+	full    bool
+}
+
+func newHistoryRing(size int) *historyRing {
+	if size < 1 {
+		size = 1
+	}
+	return &historyRing{entries: make([]HistoryEntry, size)}
+}
+
+func (h *historyRing) add(entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list возвращает записи от самой новой к самой старой
+func (h *historyRing) list() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	count := h.next
+	if h.full {
+		count = len(h.entries)
+	}
+	result := make([]HistoryEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		idx := (h.next - i + len(h.entries)) % len(h.entries)
+		result = append(result, h.entries[idx])
+	}
+	return result
+}
+
+var recentHistory = newHistoryRing(AdminHistorySize)
+
+// recordHistory сохраняет результат обработки сообщения в истории и запоминает ключ
+// завершенного сообщения, чтобы поздние повторы его сегментов не начинали новую сборку
+func recordHistory(key string, state *MessageReassemblyState, status string, output OutputMessage) {
+	finishedMessages.Add(key, time.Now())
+	recentHistory.add(HistoryEntry{
+		Key:              key,
+		Sender:           state.Sender,
+		SendTime:         state.SendTime,
+		Status:           status,
+		TotalSegments:    state.TotalSegmentsExpected,
+		ReceivedSegments: len(state.Segments),
+		FinishedAt:       time.Now(),
+		ErrorMsg:         output.ErrorMsg,
+	})
+}
+
+// RegisterAdminRoutes регистрирует защищенные эндпоинты admin API
+func RegisterAdminRoutes(r *mux.Router) {
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(adminAuth)
+	admin.HandleFunc("/messages", handleAdminList).Methods(http.MethodGet)
+	admin.HandleFunc("/messages/{key}", handleAdminGet).Methods(http.MethodGet)
+	admin.HandleFunc("/messages/{key}", handleAdminDrop).Methods(http.MethodDelete)
+	admin.HandleFunc("/messages/{key}/complete", handleAdminComplete).Methods(http.MethodPost)
+	admin.HandleFunc("/messages/{key}/expire", handleAdminExpire).Methods(http.MethodPost)
+	admin.HandleFunc("/history", handleAdminHistory).Methods(http.MethodGet)
+}
+
+// adminAuth проверяет токен в заголовке Authorization: Bearer <token>
+func adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if AdminToken == "" {
+			http.Error(w, "Admin API отключен", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			http.Error(w, "Неверный токен доступа", http.StatusUnauthorized)
+			log.Printf("Отклонен запрос к admin API: %s %s", r.Method, r.URL)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Ошибка при сериализации ответа: %v", err)
+	}
+}
+
+// pathVar возвращает декодированную переменную маршрута. Роутер сопоставляет маршруты по
+// закодированному пути (UseEncodedPath), поэтому ключ с "/" передается как %2F.
+func pathVar(r *http.Request, name string) string {
+	value := mux.Vars(r)[name]
+	if decoded, err := url.PathUnescape(value); err == nil {
+		return decoded
+	}
+	return value
+}
+
+func handleAdminList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, reassembler.Snapshot(time.Now()))
+}
+
+func handleAdminGet(w http.ResponseWriter, r *http.Request) {
+	info, ok := reassembler.Describe(pathVar(r, "key"), time.Now())
+	if !ok {
+		http.Error(w, "Сообщение не найдено", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func handleAdminComplete(w http.ResponseWriter, r *http.Request) {
+	key := pathVar(r, "key")
+	state, ok := reassembler.Take(key)
+	if !ok {
+		http.Error(w, "Сообщение не найдено", http.StatusNotFound)
+		return
+	}
+
+	output := formatPartialMessage(state)
+	recordHistory(key, state, historyForced, output)
+	deliverToApplLevel(output)
+	log.Printf("Сообщение по ключу '%s' принудительно завершено администратором", key)
+	writeJSON(w, http.StatusOK, output)
+}
+
+func handleAdminExpire(w http.ResponseWriter, r *http.Request) {
+	key := pathVar(r, "key")
+	state, ok := reassembler.Take(key)
+	if !ok {
+		http.Error(w, "Сообщение не найдено", http.StatusNotFound)
+		return
+	}
+
+	output := formatOutputMessage(state, false)
+	recordHistory(key, state, historyExpired, output)
+	deliverToApplLevel(output)
+	log.Printf("Сообщение по ключу '%s' принудительно просрочено администратором", key)
+	writeJSON(w, http.StatusOK, output)
+}
+
+func handleAdminDrop(w http.ResponseWriter, r *http.Request) {
+	key := pathVar(r, "key")
+	state, ok := reassembler.Take(key)
+	if !ok {
+		http.Error(w, "Сообщение не найдено", http.StatusNotFound)
+		return
+	}
+
+	recordHistory(key, state, historyDropped, OutputMessage{})
+	log.Printf("Сообщение по ключу '%s' удалено администратором", key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, recentHistory.list())
+}
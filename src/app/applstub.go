@@ -0,0 +1,175 @@
+package app
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Параметры заглушки прикладного уровня
+type ApplStubConfig struct {
+	FailProb   float64       // Вероятность ответа ошибкой
+	FailStatus int           // Код ответа при ошибке
+	FailFirst  int           // Количество первых запросов, на которые отвечать ошибкой
+	Delay      time.Duration // Задержка перед ответом
+	Seed       int64         // Зерно генератора случайных чисел
+	Secret     string        // Ключ проверки подписи webhook (пусто - без проверки)
+}
+
+// Запись о сообщении, полученном заглушкой прикладного уровня
+type ReceivedMessage struct {
+	ReceivedAt time.Time     `json:"received_at"`
+	Status     int           `json:"status"` // Код, которым заглушка ответила на запрос
+	Message    OutputMessage `json:"message"`
+}
+
+// ApplStub - Заглушка прикладного уровня: принимает OutputMessage от получателя http,
+// записывает их в журнал и позволяет запросить полученное для проверок в тестах.
+type ApplStub struct {
+	config ApplStubConfig
+	record io.Writer // Журнал полученных сообщений в формате JSONL (может быть nil)
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	requests int
+	received []ReceivedMessage
+}
+
+// NewApplStub создает заглушку; record - необязательный журнал полученных сообщений
+func NewApplStub(config ApplStubConfig, record io.Writer) *ApplStub {
+	if config.FailStatus == 0 {
+		config.FailStatus = http.StatusInternalServerError
+	}
+	return &ApplStub{
+		config: config,
+		record: record,
+		rng:    rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Handler возвращает обработчики заглушки:
+// POST /receive - прием сообщения, GET /received - полученные сообщения (?sender= для фильтра),
+// DELETE /received - очистка списка.
+func (a *ApplStub) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /receive", a.handleReceive)
+	mux.HandleFunc("GET /received", func(w http.ResponseWriter, r *http.Request) {
+		sender := r.URL.Query().Get("sender")
+		result := make([]ReceivedMessage, 0)
+		for _, m := range a.Received() {
+			if sender == "" || m.Message.Sender == sender {
+				result = append(result, m)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+	mux.HandleFunc("DELETE /received", func(w http.ResponseWriter, r *http.Request) {
+		a.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// Received возвращает копию списка полученных сообщений
+func (a *ApplStub) Received() []ReceivedMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ReceivedMessage(nil), a.received...)
+}
+
+// Reset очищает список полученных сообщений и счетчик запросов
+func (a *ApplStub) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.received = nil
+	a.requests = 0
+}
+
+func (a *ApplStub) handleReceive(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Ошибка чтения тела", http.StatusBadRequest)
+		return
+	}
+	if a.config.Secret != "" {
+		err := VerifyWebhookSignature(a.config.Secret, r.Header.Get(webhookTimestampHeader),
+			r.Header.Get(webhookSignatureHeader), body, 5*time.Minute, time.Now())
+		if err != nil {
+			log.Printf("[appl-stub] Отклонено уведомление: %v", err)
+			http.Error(w, "Подпись не прошла проверку", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var message OutputMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+
+	time.Sleep(a.config.Delay)
+
+	a.mu.Lock()
+	a.requests++
+	status := http.StatusOK
+	if a.requests <= a.config.FailFirst || a.rng.Float64() < a.config.FailProb {
+		status = a.config.FailStatus
+	}
+	entry := ReceivedMessage{ReceivedAt: time.Now(), Status: status, Message: message}
+	a.received = append(a.received, entry)
+	if a.record != nil {
+		if line, err := json.Marshal(entry); err == nil {
+			fmt.Fprintln(a.record, string(line))
+		}
+	}
+	a.mu.Unlock()
+
+	log.Printf("[appl-stub] %d <- %+v", status, message)
+	if status != http.StatusOK {
+		http.Error(w, "Ошибка, заданная конфигурацией заглушки", status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RunApplStub - Команда appl-stub: запускает заглушку прикладного уровня.
+func RunApplStub(args []string) error {
+	fs := flag.NewFlagSet("appl-stub", flag.ContinueOnError)
+	listen := fs.String("listen", ":8002", "адрес HTTP сервера заглушки")
+	file := fs.String("file", "", "файл журнала полученных сообщений (JSONL); по умолчанию только консоль")
+	config := ApplStubConfig{}
+	fs.Float64Var(&config.FailProb, "fail", 0, "вероятность ответа ошибкой")
+	fs.IntVar(&config.FailStatus, "fail-status", http.StatusInternalServerError, "код ответа при ошибке")
+	fs.IntVar(&config.FailFirst, "fail-first", 0, "количество первых запросов, на которые отвечать ошибкой")
+	fs.DurationVar(&config.Delay, "delay", 0, "задержка перед ответом")
+	fs.Int64Var(&config.Seed, "seed", 1, "зерно генератора случайных чисел")
+	fs.StringVar(&config.Secret, "secret", "", "ключ проверки подписи webhook (для подписок /subscriptions)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var record io.Writer
+	if *file != "" {
+		f, err := os.OpenFile(*file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("не удалось открыть файл %s: %v", *file, err)
+		}
+		defer f.Close()
+		record = f
+	}
+
+	stub := NewApplStub(config, record)
+	srv := &http.Server{Addr: *listen, Handler: stub.Handler()}
+	log.Printf("[appl-stub] Запуск на %s/receive, параметры: %+v", *listen, config)
+
+	return serveUntilSignal(srv, func() {
+		log.Printf("[appl-stub] Получено сообщений: %d", len(stub.Received()))
+	})
+}
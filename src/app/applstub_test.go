@@ -0,0 +1,139 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postToStub отправляет сообщение заглушке и возвращает код ответа
+func postToStub(t *testing.T, handler http.Handler, message OutputMessage, header http.Header) int {
+	t.Helper()
+	body, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/receive", bytes.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestApplStubResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ApplStubConfig
+		codes  []int // Коды ответов на последовательные запросы
+	}{
+		{name: "без ошибок", config: ApplStubConfig{}, codes: []int{200, 200}},
+		{name: "первые запросы с ошибкой", config: ApplStubConfig{FailFirst: 2, FailStatus: http.StatusServiceUnavailable}, codes: []int{503, 503, 200}},
+		{name: "всегда ошибка", config: ApplStubConfig{FailProb: 1}, codes: []int{500, 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := NewApplStub(tt.config, nil)
+			handler := stub.Handler()
+			for i, want := range tt.codes {
+				if code := postToStub(t, handler, OutputMessage{Sender: "alice", Payload: strconv.Itoa(i)}, nil); code != want {
+					t.Errorf("запрос %d: код %d, ожидался %d", i+1, code, want)
+				}
+			}
+			received := stub.Received()
+			if len(received) != len(tt.codes) {
+				t.Fatalf("записано %d сообщений, ожидалось %d", len(received), len(tt.codes))
+			}
+			for i, m := range received {
+				if m.Status != tt.codes[i] || m.Message.Payload != strconv.Itoa(i) {
+					t.Errorf("запись %d: %+v", i+1, m)
+				}
+			}
+		})
+	}
+}
+
+func TestApplStubSeedReproducible(t *testing.T) {
+	codes := func(seed int64) []int {
+		stub := NewApplStub(ApplStubConfig{FailProb: 0.5, Seed: seed}, nil)
+		handler := stub.Handler()
+		result := make([]int, 20)
+		for i := range result {
+			result[i] = postToStub(t, handler, OutputMessage{Sender: "alice"}, nil)
+		}
+		return result
+	}
+	first, second := codes(3), codes(3)
+	if !slices.Equal(first, second) {
+		t.Errorf("при одинаковом зерне ответы различаются: %v и %v", first, second)
+	}
+}
+
+func TestApplStubSignature(t *testing.T) {
+	stub := NewApplStub(ApplStubConfig{Secret: "s3cret"}, nil)
+	handler := stub.Handler()
+	message := OutputMessage{Sender: "alice", Payload: "привет"}
+	body, _ := json.Marshal(message)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signed := http.Header{}
+	signed.Set(webhookTimestampHeader, timestamp)
+	signed.Set(webhookSignatureHeader, signWebhook("s3cret", timestamp, body))
+	forged := http.Header{}
+	forged.Set(webhookTimestampHeader, timestamp)
+	forged.Set(webhookSignatureHeader, signWebhook("other", timestamp, body))
+
+	if code := postToStub(t, handler, message, nil); code != http.StatusUnauthorized {
+		t.Errorf("без подписи: %d", code)
+	}
+	if code := postToStub(t, handler, message, forged); code != http.StatusUnauthorized {
+		t.Errorf("чужая подпись: %d", code)
+	}
+	if code := postToStub(t, handler, message, signed); code != http.StatusOK {
+		t.Errorf("верная подпись: %d", code)
+	}
+	if n := len(stub.Received()); n != 1 {
+		t.Errorf("записано %d сообщений, ожидалось 1", n)
+	}
+}
+
+func TestApplStubReceivedAPI(t *testing.T) {
+	var record bytes.Buffer
+	stub := NewApplStub(ApplStubConfig{}, &record)
+	handler := stub.Handler()
+	for _, sender := range []string{"alice", "bob", "alice"} {
+		postToStub(t, handler, OutputMessage{Sender: sender}, nil)
+	}
+	if lines := strings.Count(record.String(), "\n"); lines != 3 {
+		t.Errorf("в журнале %d строк, ожидалось 3", lines)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/received?sender=alice", nil))
+	var received []ReceivedMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &received); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || received[0].Message.Sender != "alice" || received[1].Message.Sender != "alice" {
+		t.Errorf("фильтр по отправителю: %+v", received)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/received", nil))
+	if w.Code != http.StatusNoContent || len(stub.Received()) != 0 {
+		t.Errorf("очистка: %d, осталось %d", w.Code, len(stub.Received()))
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/receive", strings.NewReader("{")))
+	if w.Code != http.StatusBadRequest || len(stub.Received()) != 0 {
+		t.Errorf("некорректное тело: %d", w.Code)
+	}
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Параметры нагрузочного теста
+type BenchConfig struct {
+	TargetURL   string        // Базовый адрес транспортного уровня
+	Mode        string        // send - через /send, transfer - сегменты напрямую в /transfer
+	Concurrency int           // Количество параллельных отправителей
+	Duration    time.Duration // Длительность теста
+	SizeDist    string        // Распределение размера сообщения: fixed, uniform, normal, exp
+	SizeMean    int           // Средний размер сообщения в байтах
+	SizeJitter  int           // Разброс размера
+	Seed        int64         // Зерно генератора
+	ApplURL     string        // Базовый адрес appl-stub для подсчета собранных сообщений (необязательно)
+	Grace       time.Duration // Ожидание завершения сборки после окончания отправки
+}
+
+// benchSample - Результат отправки одного сообщения
+type benchSample struct {
+	sender  string
+	size    int
+	latency time.Duration
+	status  string // "ok" или описание ошибки
+}
+
+// RunBench - Команда bench: нагрузочный тест /send или /transfer.
+func RunBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	config := BenchConfig{}
+	fs.StringVar(&config.TargetURL, "target", TransportURL, "базовый адрес транспортного уровня")
+	fs.StringVar(&config.Mode, "mode", "send", "режим: send или transfer")
+	fs.IntVar(&config.Concurrency, "c", 8, "количество параллельных отправителей")
+	fs.DurationVar(&config.Duration, "d", 10*time.Second, "длительность теста")
+	fs.StringVar(&config.SizeDist, "size-dist", "fixed", "распределение размера сообщения: fixed, uniform, normal, exp")
+	fs.IntVar(&config.SizeMean, "size", 500, "средний размер сообщения в байтах")
+	fs.IntVar(&config.SizeJitter, "size-jitter", 0, "разброс размера сообщения")
+	fs.Int64Var(&config.Seed, "seed", 1, "зерно генератора случайных чисел")
+	fs.StringVar(&config.ApplURL, "appl", "", "базовый адрес appl-stub для подсчета собранных сообщений")
+	fs.DurationVar(&config.Grace, "grace", 5*time.Second, "ожидание завершения сборки после отправки")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if config.Mode != "send" && config.Mode != "transfer" {
+		return fmt.Errorf("неизвестный режим: %s", config.Mode)
+	}
+
+	fmt.Printf("Нагрузочный тест %s/%s: %d отправителей, %s, размер %s %d±%d байт\n",
+		config.TargetURL, config.Mode, config.Concurrency, config.Duration, config.SizeDist, config.SizeMean, config.SizeJitter)
+
+	samples, elapsed := runBench(config)
+	printBenchReport(os.Stdout, config, samples, elapsed)
+	return nil
+}
+
+// runBench отправляет сообщения в течение config.Duration и возвращает результаты
+func runBench(config BenchConfig) ([]benchSample, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Duration)
+	defer cancel()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	runID := time.Now().UnixNano()
+	results := make(chan []benchSample, config.Concurrency)
+	start := time.Now()
+
+	for worker := 0; worker < config.Concurrency; worker++ {
+		go func(worker int) {
+			rng := rand.New(rand.NewSource(config.Seed + int64(worker)))
+			sender := fmt.Sprintf("bench-%d-%d", runID, worker)
+			var samples []benchSample
+			for ctx.Err() == nil {
+				payload := strings.Repeat("x", benchMessageSize(config, rng))
+				began := time.Now()
+				status := benchSendOne(client, config, sender, payload, began)
+				samples = append(samples, benchSample{sender: sender, size: len(payload), latency: time.Since(began), status: status})
+			}
+			results <- samples
+		}(worker)
+	}
+
+	var all []benchSample
+	for i := 0; i < config.Concurrency; i++ {
+		all = append(all, <-results...)
+	}
+	return all, time.Since(start)
+}
+
+// benchMessageSize выбирает размер сообщения согласно распределению
+func benchMessageSize(config BenchConfig, rng *rand.Rand) int {
+	mean, jitter := float64(config.SizeMean), float64(config.SizeJitter)
+	size := mean
+	switch config.SizeDist {
+	case "uniform":
+		size = mean - jitter + rng.Float64()*2*jitter
+	case "normal":
+		size = mean + rng.NormFloat64()*jitter
+	case "exp":
+		size = rng.ExpFloat64() * mean
+	}
+	return max(int(size), 1)
+}
+
+// benchSendOne отправляет одно сообщение и возвращает "ok" или описание ошибки
+func benchSendOne(client *http.Client, config BenchConfig, sender, payload string, sendTime time.Time) string {
+	if config.Mode == "send" {
+		body, _ := json.Marshal(SendRequest{Sender: sender, SendTime: sendTime, Payload: payload})
+		return benchPost(client, config.TargetURL+"/send", body)
+	}
+
+	parts := splitSegment(payload, SegmentSize)
+	for i, part := range parts {
+		body, _ := json.Marshal(Segment{
+			SegmentNumber:  i + 1,
+			TotalSegments:  len(parts),
+			Sender:         sender,
+			SendTime:       sendTime,
+			SegmentPayload: part,
+		})
+		if status := benchPost(client, config.TargetURL+"/transfer", body); status != "ok" {
+			return status
+		}
+	}
+	return "ok"
+}
+
+func benchPost(client *http.Client, url string, body []byte) string {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "ошибка соединения"
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.Status
+	}
+	return "ok"
+}
+
+// printBenchReport выводит пропускную способность, задержки, ошибки и долю собранных сообщений.
+// Ответы 429 ограничителя частоты считаются отдельно от остальных ошибок: при их наличии тест
+// измеряет лимит TRANSPORT_RATELIMIT_SEND/TRANSFER, а не пропускную способность транспортного уровня.
+func printBenchReport(w io.Writer, config BenchConfig, samples []benchSample, elapsed time.Duration) {
+	var latencies []time.Duration
+	errorsByStatus := make(map[string]int)
+	sentBytes, rateLimited := 0, 0
+	okBySender := make(map[string]int)
+	for _, s := range samples {
+		switch {
+		case s.status == "ok":
+			latencies = append(latencies, s.latency)
+			sentBytes += s.size
+			okBySender[s.sender]++
+		case strings.HasPrefix(s.status, strconv.Itoa(http.StatusTooManyRequests)):
+			rateLimited++
+		default:
+			errorsByStatus[s.status]++
+		}
+	}
+	slices.Sort(latencies)
+
+	fmt.Fprintf(w, "\nОтправлено сообщений: %d за %s\n", len(samples), elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Успешно: %d (%.1f сообщ./с, %.1f КиБ/с)\n", len(latencies),
+		float64(len(latencies))/elapsed.Seconds(), float64(sentBytes)/1024/elapsed.Seconds())
+	if len(latencies) > 0 {
+		fmt.Fprintf(w, "Задержка: p50=%s p95=%s p99=%s max=%s\n",
+			percentile(latencies, 0.50), percentile(latencies, 0.95), percentile(latencies, 0.99), latencies[len(latencies)-1])
+	}
+	if len(samples) > 0 {
+		fmt.Fprintf(w, "Доля ошибок: %.2f%%\n", 100*float64(len(samples)-len(latencies)-rateLimited)/float64(len(samples)))
+	}
+	for status, count := range errorsByStatus {
+		fmt.Fprintf(w, "  %s: %d\n", status, count)
+	}
+	if rateLimited > 0 {
+		fmt.Fprintf(w, "Отклонено ограничителем частоты (429): %d (%.2f%%)\n", rateLimited, 100*float64(rateLimited)/float64(len(samples)))
+		fmt.Fprintf(w, "ВНИМАНИЕ: результат ограничен лимитом запросов, а не транспортным уровнем; "+
+			"запустите сервер с TRANSPORT_RATELIMIT_%s=off или увеличьте лимит\n", strings.ToUpper(config.Mode))
+	}
+
+	if config.ApplURL == "" || len(latencies) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nОжидание завершения сборки (%s)...\n", config.Grace)
+	time.Sleep(config.Grace)
+
+	assembled, failed := 0, 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for sender := range okBySender {
+		wg.Add(1)
+		go func(sender string) {
+			defer wg.Done()
+			resp, err := http.Get(config.ApplURL + "/received?sender=" + sender)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			var received []ReceivedMessage
+			if json.NewDecoder(resp.Body).Decode(&received) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, m := range received {
+				if m.Status != http.StatusOK {
+					continue // Неуспешные попытки доставки, повторенные позже
+				}
+				if m.Message.Error {
+					failed++
+				} else {
+					assembled++
+				}
+			}
+		}(sender)
+	}
+	wg.Wait()
+
+	fmt.Fprintf(w, "Собрано сообщений: %d из %d (%.2f%%), с ошибкой: %d\n",
+		assembled, len(latencies), 100*float64(assembled)/float64(len(latencies)), failed)
+}
+
+// percentile возвращает перцентиль p отсортированного набора задержек
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(idx, 0), len(sorted)-1)]
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func BenchmarkSplitSegment(b *testing.B) {
+	for _, size := range []int{140, 4096, 65536} {
+		ascii := strings.Repeat("x", size)
+		unicode := strings.Repeat("я", size/2)
+		b.Run(fmt.Sprintf("ascii-%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(ascii)))
+			for i := 0; i < b.N; i++ {
+				splitSegment(ascii, SegmentSize)
+			}
+		})
+		b.Run(fmt.Sprintf("unicode-%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(unicode)))
+			for i := 0; i < b.N; i++ {
+				splitSegment(unicode, SegmentSize)
+			}
+		})
+	}
+}
+
+// benchmarkSegments возвращает сегменты сообщения из total частей с уникальным временем отправки n
+func benchmarkSegments(sender string, n int64, total int) []Segment {
+	sendTime := time.Unix(0, n)
+	segments := make([]Segment, total)
+	for i := range segments {
+		segments[i] = Segment{
+			SegmentNumber:  i + 1,
+			TotalSegments:  total,
+			Sender:         sender,
+			SendTime:       sendTime,
+			SegmentPayload: strings.Repeat("x", SegmentSize),
+		}
+	}
+	return segments
+}
+
+func BenchmarkReassemblerAdd(b *testing.B) {
+	for _, total := range []int{1, 4, 32} {
+		b.Run(fmt.Sprintf("segments-%d", total), func(b *testing.B) {
+			r := NewReassembler(ReassemblyShards, time.Minute, ReassemblyLimits{})
+			now := time.Now()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, segment := range benchmarkSegments("bench", int64(i), total) {
+					r.Add(segment, now)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReassemblerAddParallel(b *testing.B) {
+	r := NewReassembler(ReassemblyShards, time.Minute, ReassemblyLimits{})
+	now := time.Now()
+	var worker atomic.Int64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		sender := fmt.Sprintf("bench-%d", worker.Add(1))
+		var n int64
+		for pb.Next() {
+			n++
+			for _, segment := range benchmarkSegments(sender, n, 4) {
+				r.Add(segment, now)
+			}
+		}
+	})
+}
+
+// BenchmarkReassemblerExpire измеряет обработку таймаутов при большом числе незавершенных сообщений
+func BenchmarkReassemblerExpire(b *testing.B) {
+	r := NewReassembler(ReassemblyShards, time.Minute, ReassemblyLimits{})
+	now := time.Now()
+	for i := 0; i < 100000; i++ {
+		r.Add(benchmarkSegments("idle", int64(i), 2)[0], now)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Expire(now)
+	}
+}
+
+func TestPrintBenchReportRateLimited(t *testing.T) {
+	samples := []benchSample{
+		{sender: "a", size: 10, latency: time.Millisecond, status: "ok"},
+		{sender: "a", status: "429 Too Many Requests"},
+		{sender: "b", status: "429 Too Many Requests"},
+		{sender: "b", status: "500 Internal Server Error"},
+	}
+	var out strings.Builder
+	printBenchReport(&out, BenchConfig{Mode: "send"}, samples, time.Second)
+	report := out.String()
+	for _, want := range []string{"Доля ошибок: 25.00%", "500 Internal Server Error: 1", "(429): 2 (50.00%)", "TRANSPORT_RATELIMIT_SEND=off"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("в отчете нет %q:\n%s", want, report)
+		}
+	}
+
+	out.Reset()
+	printBenchReport(&out, BenchConfig{Mode: "send"}, samples[:1], time.Second)
+	if strings.Contains(out.String(), "ВНИМАНИЕ") {
+		t.Errorf("предупреждение без ответов 429:\n%s", out.String())
+	}
+}
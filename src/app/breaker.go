@@ -0,0 +1,213 @@
+package app
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Состояния автоматического выключателя
+const (
+	breakerClosed   = "closed"    // Запросы проходят, считаются неудачи подряд
+	breakerOpen     = "open"      // Запросы отклоняются без обращения к адресу
+	breakerHalfOpen = "half-open" // Пропускается ограниченное число пробных запросов
+)
+
+// Результат запроса через выключатель
+type breakerOutcome int
+
+const (
+	breakerSuccess breakerOutcome = iota // Адрес ответил
+	breakerFailure                       // Ошибка соединения, таймаут или ответ 5xx
+	breakerIgnore                        // Запрос отменен вызывающей стороной, не учитывается
+)
+
+// errCircuitOpen - Адрес недоступен, запрос отклонен выключателем без попытки соединения
+var errCircuitOpen = errors.New("адрес недоступен, выключатель разомкнут")
+
+// breakerMetrics - Состояние и счетчики выключателей: <url>.state, <url>.opened, <url>.rejected
+var breakerMetrics = expvar.NewMap("circuit_breakers")
+
+// circuitBreaker - Автоматический выключатель запросов к одному адресу
+type circuitBreaker struct {
+	url   string
+	state *expvar.String
+
+	mu         sync.Mutex
+	current    string
+	generation uint64 // Увеличивается при каждой смене состояния; результаты прошлых состояний не учитываются
+	failures   int    // Неудачи подряд в замкнутом состоянии
+	openedAt   time.Time
+	probes     int // Пробные запросы в полуразомкнутом состоянии
+	successes  int // Успешные пробные запросы
+}
+
+// breakerRegistry - Выключатели по адресам
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// Выключатели исходящих запросов к канальному и прикладному уровням
+var breakers = &breakerRegistry{breakers: make(map[string]*circuitBreaker)}
+
+// breakerFor возвращает выключатель адреса; nil, если выключатели отключены (BreakerFailures = 0)
+func breakerFor(url string) *circuitBreaker {
+	if BreakerFailures <= 0 {
+		return nil
+	}
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	b, ok := breakers.breakers[url]
+	if !ok {
+		b = &circuitBreaker{url: url, state: new(expvar.String), current: breakerClosed}
+		b.state.Set(breakerClosed)
+		breakerMetrics.Set(url+".state", b.state)
+		breakers.breakers[url] = b
+	}
+	return b
+}
+
+// retryAfter возвращает время до пробных запросов разомкнутого выключателя (0 - запросы пропускаются).
+// В отличие от allow не занимает место пробного запроса.
+func (b *circuitBreaker) retryAfter(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current != breakerOpen {
+		return 0
+	}
+	return max(BreakerOpenTimeout-now.Sub(b.openedAt), 0)
+}
+
+// allow разрешает запрос и возвращает поколение для record; errCircuitOpen - запрос отклонен
+func (b *circuitBreaker) allow(now time.Time) (uint64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current == breakerOpen && now.Sub(b.openedAt) >= BreakerOpenTimeout {
+		b.setStateLocked(breakerHalfOpen, now)
+	}
+	switch b.current {
+	case breakerOpen:
+		breakerMetrics.Add(b.url+".rejected", 1)
+		return 0, errCircuitOpen
+	case breakerHalfOpen:
+		if b.probes >= max(BreakerHalfOpenProbes, 1) {
+			breakerMetrics.Add(b.url+".rejected", 1)
+			return 0, errCircuitOpen
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// record учитывает результат запроса, разрешенного allow в поколении generation
+func (b *circuitBreaker) record(generation uint64, outcome breakerOutcome, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	switch b.current {
+	case breakerClosed:
+		switch outcome {
+		case breakerSuccess:
+			b.failures = 0
+		case breakerFailure:
+			if b.failures++; b.failures >= BreakerFailures {
+				b.setStateLocked(breakerOpen, now)
+			}
+		}
+	case breakerHalfOpen:
+		b.probes--
+		switch outcome {
+		case breakerSuccess:
+			if b.successes++; b.successes >= max(BreakerHalfOpenProbes, 1) {
+				b.setStateLocked(breakerClosed, now)
+			}
+		case breakerFailure:
+			b.setStateLocked(breakerOpen, now)
+		}
+	}
+}
+
+// setStateLocked переводит выключатель в состояние state и обновляет метрики и готовность
+func (b *circuitBreaker) setStateLocked(state string, now time.Time) {
+	b.current = state
+	b.generation++
+	b.failures, b.probes, b.successes = 0, 0, 0
+	b.state.Set(state)
+
+	// Готовность адресов канального уровня определяется пулом: достаточно одного доступного адреса
+	component := "circuit:" + b.url
+	report := !isChannelEndpoint(b.url)
+	switch state {
+	case breakerOpen:
+		b.openedAt = now
+		breakerMetrics.Add(b.url+".opened", 1)
+		log.Printf("Выключатель %s разомкнут на %s", b.url, BreakerOpenTimeout)
+		if report {
+			setReadiness(component, fmt.Errorf("%w, проверка через %s", errCircuitOpen, BreakerOpenTimeout))
+		}
+		// Переход к пробным запросам без ожидания очередного запроса, чтобы /readyz восстановился
+		generation := b.generation
+		time.AfterFunc(BreakerOpenTimeout, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.generation == generation {
+				b.setStateLocked(breakerHalfOpen, time.Now())
+			}
+		})
+	case breakerHalfOpen:
+		log.Printf("Выключатель %s: пробные запросы", b.url)
+		if report {
+			setReadiness(component, nil)
+		}
+	case breakerClosed:
+		log.Printf("Выключатель %s замкнут, адрес снова доступен", b.url)
+		if report {
+			setReadiness(component, nil)
+		}
+	}
+}
+
+// breakerResult определяет результат запроса по ошибке транспорта и статусу ответа
+func breakerResult(err error, status int, canceled bool) breakerOutcome {
+	switch {
+	case canceled:
+		return breakerIgnore
+	case err != nil || status >= 500:
+		return breakerFailure
+	}
+	return breakerSuccess
+}
+
+// rejectChannelUnavailable отвечает 503 с Retry-After, если выключатели всех адресов канального уровня разомкнуты
+func rejectChannelUnavailable(w http.ResponseWriter) bool {
+	pool, err := channelPool()
+	if err != nil {
+		return false // Ошибка конфигурации сообщается при отправке сегментов
+	}
+	retryAfter := pool.retryAfter(time.Now())
+	if retryAfter <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, fmt.Sprintf("Канальный уровень недоступен (%v), повторите позже", errCircuitOpen), http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withBreakerSettings задает пороги выключателей на время теста
+func withBreakerSettings(t *testing.T, failures int, openTimeout time.Duration, probes int) {
+	t.Helper()
+	savedFailures, savedTimeout, savedProbes, savedBreakers := BreakerFailures, BreakerOpenTimeout, BreakerHalfOpenProbes, breakers
+	t.Cleanup(func() {
+		for url := range breakers.breakers {
+			setReadiness("circuit:"+url, nil)
+		}
+		BreakerFailures, BreakerOpenTimeout, BreakerHalfOpenProbes, breakers = savedFailures, savedTimeout, savedProbes, savedBreakers
+	})
+	BreakerFailures, BreakerOpenTimeout, BreakerHalfOpenProbes = failures, openTimeout, probes
+	breakers = &breakerRegistry{breakers: make(map[string]*circuitBreaker)}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	withBreakerSettings(t, 3, time.Hour, 1)
+	b := breakerFor("http://breaker.test/transfer")
+	component := "circuit:http://breaker.test/transfer"
+	now := time.Now()
+
+	request := func(outcome breakerOutcome) error {
+		t.Helper()
+		generation, err := b.allow(now)
+		if err == nil {
+			b.record(generation, outcome, now)
+		}
+		return err
+	}
+	notReady := func() bool {
+		_, failures := readinessReport()
+		_, ok := failures[component]
+		return ok
+	}
+
+	// Успех сбрасывает счетчик неудач подряд
+	request(breakerFailure)
+	request(breakerFailure)
+	request(breakerSuccess)
+	request(breakerFailure)
+	request(breakerIgnore)
+	request(breakerFailure)
+	if b.current != breakerClosed {
+		t.Fatalf("состояние %s после неудач не подряд, ожидалось %s", b.current, breakerClosed)
+	}
+
+	// Результат запроса, начатого до размыкания, не учитывается
+	stale, _ := b.allow(now)
+	request(breakerFailure)
+	if b.current != breakerOpen || !notReady() {
+		t.Fatalf("состояние %s после 3 неудач подряд, ожидалось %s и неготовность", b.current, breakerOpen)
+	}
+	if err := request(breakerSuccess); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("запрос при разомкнутом выключателе: %v", err)
+	}
+	b.record(stale, breakerSuccess, now)
+	if b.current != breakerOpen {
+		t.Fatalf("устаревший результат изменил состояние на %s", b.current)
+	}
+	if retryAfter := b.retryAfter(now.Add(time.Minute)); retryAfter != 59*time.Minute {
+		t.Errorf("retryAfter = %s, ожидалось 59m", retryAfter)
+	}
+
+	// После таймаута пропускается один пробный запрос; неудача снова размыкает выключатель
+	now = now.Add(time.Hour)
+	probe, err := b.allow(now)
+	if err != nil || b.current != breakerHalfOpen || notReady() {
+		t.Fatalf("пробный запрос: %v, состояние %s", err, b.current)
+	}
+	if _, err := b.allow(now); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("второй одновременный пробный запрос: %v", err)
+	}
+	b.record(probe, breakerFailure, now)
+	if b.current != breakerOpen {
+		t.Fatalf("состояние %s после неудачного пробного запроса", b.current)
+	}
+
+	// Успешный пробный запрос замыкает выключатель
+	now = now.Add(time.Hour)
+	if err := request(breakerSuccess); err != nil || b.current != breakerClosed || notReady() {
+		t.Fatalf("успешный пробный запрос: %v, состояние %s", err, b.current)
+	}
+}
+
+func TestSendFailsFastWhenChannelDown(t *testing.T) {
+	withBreakerSettings(t, 2, time.Hour, 1)
+	savedChannel, savedLimiter := urlChannelLevel, sendLimiter
+	t.Cleanup(func() { urlChannelLevel, sendLimiter = savedChannel, savedLimiter })
+	sendLimiter = nil
+
+	var requests atomic.Int32
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "канальный уровень недоступен", http.StatusBadGateway)
+	}))
+	defer channel.Close()
+	urlChannelLevel = channel.URL
+
+	send := func(sender string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(SendRequest{Sender: sender, SendTime: time.Now(), Payload: strings.Repeat("x", 2*SegmentSize)})
+		w := httptest.NewRecorder()
+		HandleSend(w, httptest.NewRequest(http.MethodPost, "/send", bytes.NewReader(body)))
+		return w
+	}
+
+	// Две неудачи размыкают выключатель
+	if w := send("breaker-1"); w.Code != http.StatusInternalServerError && w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("первая отправка: %d %s", w.Code, w.Body)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("запросов к канальному уровню %d, ожидалось 2", n)
+	}
+
+	w := send("breaker-2")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("отправка при разомкнутом выключателе: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("запросов к канальному уровню %d, отправка должна быть отклонена без запросов", n)
+	}
+}
@@ -1,14 +1,10 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
-	"strings"
-	"sync"
 	"time"
 
 	kafka "github.com/confluentinc/confluent-kafka-go/kafka"
@@ -16,42 +12,45 @@ import (
 
 // Структура для финального сообщения на прикладной уровень
 type OutputMessage struct {
-	Sender   string    `json:"sender"`
-	SendTime time.Time `json:"send_time"`
-	Payload  string    `json:"payload"`             // Собранная полезная нагрузка
-	Error    bool      `json:"error,omitempty"`     // Признак ошибки (опускается, если false)
-	ErrorMsg string    `json:"error_msg,omitempty"` // Сообщение об ошибке (опускается, если пустое)
+	Sender    string    `json:"sender"`
+	SendTime  time.Time `json:"send_time"`
+	Payload   string    `json:"payload"`             // Собранная полезная нагрузка
+	Error     bool      `json:"error,omitempty"`     // Признак ошибки (опускается, если false)
+	ErrorMsg  string    `json:"error_msg,omitempty"` // Сообщение об ошибке (опускается, если пустое)
+	Recipient string    `json:"recipient,omitempty"` // Получатель, указанный отправителем (опускается, если не задан)
 }
 
-// Структура для хранения состояния сборки одного логического сообщения
-type MessageReassemblyState struct {
-	Segments              map[int]Segment // Хранит полученные сегменты по их номеру
-	TotalSegmentsExpected int             // Общее количество ожидаемых сегментов
-	LastSegmentArrivalTime time.Time       // Время поступления последнего сегмента для этого сообщения
-	Sender                string
-	SendTime              time.Time
-}
-
-// Коллекция незавершенных сообщений, ожидающих сегменты
-// Ключ - уникальный идентификатор сообщения (например, Sender + SendTime)
-var (
-	inFlightMessages map[string]*MessageReassemblyState
-	inFlightMutex    sync.Mutex // Мьютекс для защиты доступа к map
-)
+// Движок сборки незавершенных сообщений, ожидающих сегменты
+var reassembler = NewReassembler(ReassemblyShards, MaxInactivityCycles, ReassemblyLimits{
+	MaxSegmentsPerMessage: MaxSegmentsPerMessage,
+	MaxMessageBytes:       MaxMessageBytes,
+	MaxInFlightMessages:   MaxInFlightMessages,
+	MaxInFlightPerSender:  MaxInFlightPerSender,
+	MaxBufferedBytes:      MaxBufferedBytes,
+})
 
 // ReassemblyGoroutine - Горутина для сборки сегментов из Kafka.
 func ReassemblyGoroutine(ctx context.Context) {
 	log.Println("Запуск горутины сборки сегментов...")
 
+	// Проверка (и при необходимости создание) топиков до подписки, иначе consumer молча ждал бы их появления
+	if !waitForTopics(ctx) {
+		log.Println("Горутина сборки сегментов завершена до готовности топиков.")
+		return
+	}
+
 	// Создание Kafka consumer с настройками
-	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers":     KafkaAddr,
+	config, err := kafkaConfigMap(kafka.ConfigMap{
 		"group.id":              "segment-reassembly-group",
 		"auto.offset.reset":     "earliest",
 		"enable.auto.commit":    false,
 		"session.timeout.ms":    10000,
 		"heartbeat.interval.ms": 3000,
 	})
+	if err != nil {
+		log.Fatalf("Некорректные параметры подключения к Kafka: %v", err)
+	}
+	consumer, err := kafka.NewConsumer(config)
 	if err != nil {
 		log.Fatalf("Не удалось создать Kafka consumer: %v", err)
 	}
@@ -64,12 +63,16 @@ func ReassemblyGoroutine(ctx context.Context) {
 	}
 	log.Printf("Kafka consumer подписан на топик: %s", KafkaTopic)
 
-	// Инициализация коллекции незавершенных сообщений
-	inFlightMessages = make(map[string]*MessageReassemblyState)
+	// Таймер срабатывает к ближайшему сроку таймаута незавершенных сообщений
+	timeoutTimer := time.NewTimer(MaxInactivityCycles)
+	defer timeoutTimer.Stop()
 
-	// Настройка таймера для периодической проверки незавершенных сообщений
-	ticker := time.NewTicker(BuildInterval)
-	defer ticker.Stop()
+	// Восстановление незавершенных сообщений, сохраненных при прошлой остановке
+	for _, result := range reassembler.Restore(restoreInFlight(), time.Now()) {
+		log.Printf("Сообщение по ключу '%s' вытеснено при восстановлении: %s", result.Key, result.Output.ErrorMsg)
+		finishMessage(result)
+	}
+	resetTimeoutTimer(timeoutTimer)
 
 	log.Println("Горутина сборки сегментов запущена.")
 
@@ -79,8 +82,9 @@ func ReassemblyGoroutine(ctx context.Context) {
 		case <-ctx.Done():
 			// Завершение горутины при получении сигнала через контекст
 			log.Println("Получен сигнал на завершение горутины сборки сегментов.")
-			consumer.Close() // Закрытие Kafka consumer
+			consumer.Close() // Закрытие Kafka consumer, новые сегменты больше не читаются
 			log.Println("Kafka consumer закрыт.")
+			drainInFlight()
 			return
 
 		case ev := <-consumer.Events(): // Обработка событий Kafka
@@ -89,6 +93,7 @@ func ReassemblyGoroutine(ctx context.Context) {
 				log.Printf("Получены назначения разделов: %v", e)
 			case kafka.RevokedPartitions:
 				log.Printf("Отзыв разделов: %v", e)
+				forgetDeadLetters()
 			case kafka.PartitionEOF:
 				log.Printf("Достигнут конец раздела %v", e)
 			case kafka.Error:
@@ -99,83 +104,39 @@ func ReassemblyGoroutine(ctx context.Context) {
 				}
 			}
 
-		case <-ticker.C:
-			// Периодическая проверка таймаутов и завершенных сообщений
-			now := time.Now()
-			keysToSend := []string{}
-			inFlightMutex.Lock()
-
-			// Проверка незавершенных сообщений
-			for key, state := range inFlightMessages {
-				if len(state.Segments) == state.TotalSegmentsExpected {
-					log.Printf("Сообщение по ключу '%s' полностью собрано.", key)
-					outputSuccessMessage := formatOutputMessage(state, true)
-					// Отправляем успешное сообщение
-					go sendToApplLevel(outputSuccessMessage)
-					keysToSend = append(keysToSend, key)
-				} else if now.Sub(state.LastSegmentArrivalTime) > MaxInactivityCycles {
-					log.Printf("Сообщение по ключу '%s' истек таймаут", key)
-					outputErrMessage := formatOutputMessage(state, false)
-					// Отправляем сообщение об ошибке
-					go sendToApplLevel(outputErrMessage)
-					keysToSend = append(keysToSend, key)
-				}
-			}
-
-			// Удаление завершенных сообщений
-			for _, key := range keysToSend {
-				delete(inFlightMessages, key)
-				log.Printf("Сообщение по ключу '%s' удалено из коллекции.", key)
-			}
-			inFlightMutex.Unlock()
+		case <-timeoutTimer.C:
+			// Обработка сообщений, у которых истек срок ожидания следующего сегмента
+			expireMessages()
+			resetTimeoutTimer(timeoutTimer)
 
 		default:
 			// Чтение сообщений из Kafka
+			resumeDeadLetterPartitions(consumer, time.Now())
 			msg, err := consumer.ReadMessage(100 * time.Millisecond)
 			if err == nil {
+				// Запись, которую не удалось опубликовать в DLQ, публикуется повторно без обработки
+				if reason, ok := pendingDeadLetter(msg); ok {
+					rejectSegment(consumer, msg, reason)
+					continue
+				}
+
 				var segment Segment
 				err = json.Unmarshal(msg.Value, &segment)
 				if err != nil {
 					log.Printf("Ошибка при десериализации сегмента: %v", err)
+					rejectSegment(consumer, msg, fmt.Sprintf("ошибка десериализации: %v", err))
 					continue
 				}
 
 				log.Printf("Обработка сегмента %d/%d: Отправитель='%s', Время='%s'", segment.SegmentNumber, segment.TotalSegments, segment.Sender, segment.SendTime.Format(time.RFC3339))
 
-				// Формирование уникального ключа для сообщения
-				messageKey := fmt.Sprintf("%s_%s", segment.Sender, segment.SendTime.Format(time.RFC3339Nano))
-
-				inFlightMutex.Lock()
-
-				// Обработка состояния сборки для сообщения
-				state, exists := inFlightMessages[messageKey]
-				if !exists {
-					state = &MessageReassemblyState{
-						Segments: make(map[int]Segment),
-						TotalSegmentsExpected: segment.TotalSegments,
-						Sender:                segment.Sender,
-						SendTime:              segment.SendTime,
-					}
-					inFlightMessages[messageKey] = state
-				} else {
-					if state.TotalSegmentsExpected != segment.TotalSegments || state.Sender != segment.Sender || !state.SendTime.Equal(segment.SendTime) {
-						log.Printf("Несоответствие метаданных сегмента для ключа '%s'", messageKey)
-						inFlightMutex.Unlock()
-						continue
-					}
-				}
-
-				// Добавление нового сегмента
-				if _, received := state.Segments[segment.SegmentNumber]; !received {
-					state.Segments[segment.SegmentNumber] = segment
-					state.LastSegmentArrivalTime = time.Now()
-					log.Printf("Добавлен сегмент %d/%d для сообщения '%s'", segment.SegmentNumber, state.TotalSegmentsExpected, messageKey)
-				} else {
-					log.Printf("Получен дубликат сегмента %d для сообщения '%s'", segment.SegmentNumber, messageKey)
+				reason := processSegment(segment)
+				resetTimeoutTimer(timeoutTimer)
+				if reason != "" {
+					rejectSegment(consumer, msg, reason)
+					continue
 				}
 
-				inFlightMutex.Unlock()
-
 				// Коммит оффсета после обработки сегмента
 				_, commitErr := consumer.CommitMessage(msg)
 				if commitErr != nil {
@@ -189,68 +150,68 @@ func ReassemblyGoroutine(ctx context.Context) {
 	}
 }
 
-// formatOutputMessage - Вспомогательная функция для форматирования финального сообщения OutputMessage
-func formatOutputMessage(state *MessageReassemblyState, success bool) OutputMessage {
-	output := OutputMessage{
-		Sender: state.Sender,
-		SendTime: state.SendTime,
+// processSegment передает сегмент движку сборки и отправляет собранные и вытесненные сообщения.
+// Возвращает причину отклонения сегмента или пустую строку, если сегмент принят.
+func processSegment(segment Segment) string {
+	key := messageKey(segment.Sender, segment.SendTime)
+	if segment.Type != segmentData {
+		return fmt.Sprintf("сегмент типа %q не является частью сообщения", segment.Type)
 	}
-
-	if success {
-		// Собираем полезную нагрузку из сегментов
-		payloadBuilder := strings.Builder{}
-		for i := 1; i <= state.TotalSegmentsExpected; i++ {
-			segment, ok := state.Segments[i]
-			if ok {
-				payloadBuilder.WriteString(segment.SegmentPayload)
-			} else {
-				// Это случай ошибки сборки, хотя мы форматируем как "успех"
-				// В реальном приложении, возможно, стоило бы пометить это как ошибку или логировать
-				log.Printf("Внимание: Отсутствует сегмент %d для сообщения '%s' при сборке успешной полезной нагрузки.", i, state.Sender)
-			}
+	if finishedMessages.Contains(key, time.Now()) {
+		// Поздний повтор (например, повторная отправка канальным уровнем) уже завершенного сообщения
+		dedupMetrics.Add("reassembly", 1)
+		log.Printf("Отброшен повтор сегмента %d для завершенного сообщения '%s'", segment.SegmentNumber, key)
+		return ""
+	}
+	status, results := reassembler.Add(segment, time.Now())
+	if status == SegmentAdded || status == SegmentCompleted || status == SegmentDuplicate {
+		publishSegmentEvent(key, segment, status == SegmentDuplicate)
+	}
+	for _, result := range results {
+		if result.Status == historyEvicted {
+			log.Printf("Сообщение по ключу '%s' вытеснено: %s", result.Key, result.Output.ErrorMsg)
+		} else {
+			log.Printf("Сообщение по ключу '%s' полностью собрано.", result.Key)
 		}
-		output.Payload = payloadBuilder.String()
-		output.Error = false
-	} else {
-		// Сообщение об ошибке
-		output.Error = true
-		output.ErrorMsg = fmt.Sprintf("Истек таймаут сообщения. Ожидалось %d сегментов, получено %d.", state.TotalSegmentsExpected, len(state.Segments))
-		output.Payload = "" // Полезная нагрузка отсутствует при ошибке
+		finishMessage(result)
 	}
 
-	return output
-}
-
-// sendToApplLevel отправляет собранное сообщение POST запросом на прикладной уровень.
-func sendToApplLevel(message OutputMessage) {
-	log.Printf("[<-] Сообщение, отправляемое на прикладной уровень: %+v", message)
-
-	jsonData, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Ошибка при маршалинге сообщения для отправки: %v", err)
-		return
+	switch status {
+	case SegmentInvalid:
+		return fmt.Sprintf("некорректный номер сегмента %d/%d", segment.SegmentNumber, segment.TotalSegments)
+	case SegmentRejected:
+		return fmt.Sprintf("сегмент %d/%d превышает лимиты сборки для ключа '%s'", segment.SegmentNumber, segment.TotalSegments, key)
+	case SegmentMismatch:
+		log.Printf("Несоответствие метаданных сегмента для ключа '%s'", key)
+		return fmt.Sprintf("несоответствие метаданных сегмента для ключа '%s'", key)
+	case SegmentDuplicate:
+		log.Printf("Получен дубликат сегмента %d для сообщения '%s'", segment.SegmentNumber, key)
+	case SegmentAdded:
+		log.Printf("Добавлен сегмент %d/%d для сообщения '%s'", segment.SegmentNumber, segment.TotalSegments, key)
 	}
+	return ""
+}
 
-	req, err := http.NewRequest("POST", urlApplLevel, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Ошибка при создании POST запроса: %v", err)
-		return
+// expireMessages отправляет уведомления об ошибке для сообщений с истекшим сроком ожидания
+func expireMessages() {
+	for _, result := range reassembler.Expire(time.Now()) {
+		log.Printf("Сообщение по ключу '%s' истек таймаут", result.Key)
+		finishMessage(result)
 	}
-	req.Header.Set("Content-Type", "application/json")
+}
 
-	client := &http.Client{Timeout: 10 * time.Second} // Ограничиваем время ожидания ответа
+// finishMessage сохраняет результат сборки в истории и отправляет сообщение на прикладной уровень
+func finishMessage(result ReassemblyResult) {
+	recordHistory(result.Key, result.State, result.Status, result.Output)
+	deliverToApplLevel(result.Output)
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Ошибка при отправке POST запроса на %s: %v", urlApplLevel, err)
+// resetTimeoutTimer переустанавливает таймер на ближайший срок таймаута
+func resetTimeoutTimer(timer *time.Timer) {
+	deadline, ok := reassembler.NextDeadline()
+	if !ok {
+		timer.Stop()
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Получен некорректный статус ответа от %s: %d", urlApplLevel, resp.StatusCode)
-		// Можно прочитать тело ответа для получения подробностей об ошибке
-	} else {
-		log.Printf("Сообщение успешно отправлено на %s", urlApplLevel)
-	}
-}
\ No newline at end of file
+	timer.Reset(max(time.Until(deadline), 0))
+}
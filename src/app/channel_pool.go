@@ -0,0 +1,342 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Стратегии выбора адреса канального уровня
+const (
+	strategyRoundRobin    = "round-robin"    // По очереди
+	strategyLeastInFlight = "least-inflight" // Адрес с наименьшим числом выполняющихся запросов
+	strategyWeighted      = "weighted"       // По очереди пропорционально весам (weight=N)
+	strategySticky        = "sticky"         // Все сегменты одного сообщения на один адрес
+)
+
+// readinessChannel - Компонент готовности: есть хотя бы один доступный адрес канального уровня
+const readinessChannel = "channel"
+
+// channelPoolMetrics - Счетчики адресов канального уровня: <url>.sent, <url>.failed, <url>.failover,
+// <url>.healthy, <url>.in_flight
+var channelPoolMetrics = expvar.NewMap("channel_pool")
+
+// channelEndpoint - Адрес канального уровня в пуле
+type channelEndpoint struct {
+	url      string
+	weight   int
+	inFlight atomic.Int64
+	health   *expvar.Int // 1 - адрес доступен
+
+	// Защищены mu пула
+	healthy        bool
+	failures       int // Неудачи подряд
+	unhealthySince time.Time
+	currentWeight  int // Текущий вес для стратегии weighted
+}
+
+// endpointPool - Адреса канального уровня и стратегия выбора
+type endpointPool struct {
+	source    string // Значение urlChannelLevel, из которого собран пул
+	strategy  string
+	endpoints []*channelEndpoint
+	next      atomic.Uint64 // Счетчик стратегии round-robin
+
+	mu sync.Mutex
+}
+
+// Текущий пул; пересобирается при изменении urlChannelLevel или ChannelStrategy
+var (
+	channelPoolMu      sync.Mutex
+	currentChannelPool atomic.Pointer[endpointPool]
+)
+
+// channelPool возвращает пул адресов канального уровня из TRANSPORT_CHANNEL_URL
+func channelPool() (*endpointPool, error) {
+	if p := currentChannelPool.Load(); p != nil && p.source == urlChannelLevel && p.strategy == ChannelStrategy {
+		return p, nil
+	}
+	channelPoolMu.Lock()
+	defer channelPoolMu.Unlock()
+	if p := currentChannelPool.Load(); p != nil && p.source == urlChannelLevel && p.strategy == ChannelStrategy {
+		return p, nil
+	}
+	p, err := newEndpointPool(urlChannelLevel, ChannelStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный TRANSPORT_CHANNEL_URL %q: %v", urlChannelLevel, err)
+	}
+	currentChannelPool.Store(p)
+	setReadiness(readinessChannel, nil)
+	return p, nil
+}
+
+// CheckChannelPool проверяет адреса канального уровня и стратегию выбора
+func CheckChannelPool() error {
+	_, err := channelPool()
+	return err
+}
+
+// newEndpointPool разбирает список вида "http://a:8081/code;weight=3,http://b:8081/code"
+func newEndpointPool(value, strategy string) (*endpointPool, error) {
+	switch strategy {
+	case strategyRoundRobin, strategyLeastInFlight, strategyWeighted, strategySticky:
+	default:
+		return nil, fmt.Errorf("неизвестная стратегия %q", strategy)
+	}
+
+	p := &endpointPool{source: value, strategy: strategy}
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		address, options, _ := strings.Cut(item, ";")
+		if u, err := url.Parse(address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("некорректный адрес %q", address)
+		}
+		if seen[address] {
+			return nil, fmt.Errorf("адрес %q указан повторно", address)
+		}
+		seen[address] = true
+
+		endpoint := &channelEndpoint{url: address, weight: 1, health: new(expvar.Int), healthy: true}
+		for _, option := range strings.Split(options, ";") {
+			if option == "" {
+				continue
+			}
+			name, v, _ := strings.Cut(option, "=")
+			weight, err := strconv.Atoi(v)
+			if name != "weight" || err != nil || weight < 1 {
+				return nil, fmt.Errorf("некорректный параметр %q адреса %s", option, address)
+			}
+			endpoint.weight = weight
+		}
+		endpoint.health.Set(1)
+		channelPoolMetrics.Set(address+".healthy", endpoint.health)
+		channelPoolMetrics.Set(address+".in_flight", expvar.Func(func() any { return endpoint.inFlight.Load() }))
+		p.endpoints = append(p.endpoints, endpoint)
+	}
+	if len(p.endpoints) == 0 {
+		return nil, errors.New("не указан ни один адрес")
+	}
+	return p, nil
+}
+
+// isChannelEndpoint сообщает, входит ли адрес в текущий пул; готовность таких адресов определяет пул
+func isChannelEndpoint(address string) bool {
+	if p := currentChannelPool.Load(); p != nil {
+		for _, endpoint := range p.endpoints {
+			if endpoint.url == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pick выбирает адрес для сегмента сообщения key, пропуская уже опробованные (tried).
+// Предпочтение отдается доступным адресам с замкнутым выключателем.
+func (p *endpointPool) pick(key string, tried map[*channelEndpoint]bool, now time.Time) *channelEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var candidates, untried []*channelEndpoint
+	for _, endpoint := range p.endpoints {
+		if tried[endpoint] {
+			continue
+		}
+		untried = append(untried, endpoint)
+		if p.usableLocked(endpoint, now) && breakerFor(endpoint.url).retryAfter(now) == 0 {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = untried
+	}
+	if len(candidates) == 0 {
+		candidates = p.endpoints
+	}
+
+	switch p.strategy {
+	case strategyLeastInFlight:
+		start := int(p.next.Add(1) - 1)
+		best := candidates[start%len(candidates)]
+		for i := range candidates {
+			if c := candidates[(start+i)%len(candidates)]; c.inFlight.Load() < best.inFlight.Load() {
+				best = c
+			}
+		}
+		return best
+	case strategyWeighted:
+		// Плавный взвешенный перебор: адрес с весом 3 получает 3 запроса из каждых (3 + остальные веса)
+		total := 0
+		var best *channelEndpoint
+		for _, c := range candidates {
+			c.currentWeight += c.weight
+			total += c.weight
+			if best == nil || c.currentWeight > best.currentWeight {
+				best = c
+			}
+		}
+		best.currentWeight -= total
+		return best
+	case strategySticky:
+		// Rendezvous hashing: при недоступности адреса переезжают только его сообщения
+		var best *channelEndpoint
+		var bestScore uint64
+		for _, c := range candidates {
+			h := fnv.New64a()
+			h.Write([]byte(key + "|" + c.url))
+			if score := h.Sum64(); best == nil || score > bestScore {
+				best, bestScore = c, score
+			}
+		}
+		return best
+	}
+	return candidates[int(p.next.Add(1)-1)%len(candidates)]
+}
+
+// usableLocked сообщает, можно ли отправлять на адрес: он доступен или пора проверить его снова
+func (p *endpointPool) usableLocked(endpoint *channelEndpoint, now time.Time) bool {
+	return endpoint.healthy || now.Sub(endpoint.unhealthySince) >= ChannelRecheck
+}
+
+// observe учитывает результат отправки сегмента на адрес (пассивная проверка)
+func (p *endpointPool) observe(endpoint *channelEndpoint, outcome breakerOutcome, err error, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch outcome {
+	case breakerSuccess:
+		channelPoolMetrics.Add(endpoint.url+".sent", 1)
+		endpoint.failures = 0
+		p.setHealthyLocked(endpoint, nil, now)
+	case breakerFailure:
+		channelPoolMetrics.Add(endpoint.url+".failed", 1)
+		if endpoint.failures++; endpoint.failures >= max(ChannelUnhealthyAfter, 1) {
+			p.setHealthyLocked(endpoint, err, now)
+		}
+	}
+}
+
+// setHealthyLocked отмечает адрес доступным (err == nil) или недоступным и обновляет готовность пула
+func (p *endpointPool) setHealthyLocked(endpoint *channelEndpoint, err error, now time.Time) {
+	healthy := err == nil
+	if !healthy {
+		endpoint.unhealthySince = now // Повторная проверка откладывается и для уже недоступного адреса
+	}
+	if endpoint.healthy != healthy {
+		endpoint.healthy = healthy
+		if healthy {
+			endpoint.health.Set(1)
+			log.Printf("Адрес канального уровня %s снова доступен", endpoint.url)
+		} else {
+			endpoint.health.Set(0)
+			log.Printf("Адрес канального уровня %s недоступен: %v", endpoint.url, err)
+		}
+	}
+
+	for _, e := range p.endpoints {
+		if p.usableLocked(e, now) {
+			setReadiness(readinessChannel, nil)
+			return
+		}
+	}
+	setReadiness(readinessChannel, errors.New("нет доступных адресов канального уровня"))
+}
+
+// retryAfter возвращает время до пробных запросов, если выключатели всех адресов разомкнуты (0 - есть доступный)
+func (p *endpointPool) retryAfter(now time.Time) time.Duration {
+	var wait time.Duration
+	for i, endpoint := range p.endpoints {
+		d := breakerFor(endpoint.url).retryAfter(now)
+		if d <= 0 {
+			return 0
+		}
+		if i == 0 || d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// checkHealth проверяет все адреса пула запросом HEAD (активная проверка)
+func (p *endpointPool) checkHealth(ctx context.Context, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func(endpoint *channelEndpoint) {
+			defer wg.Done()
+			err := probeChannelEndpoint(ctx, endpoint.url, timeout)
+			if ctx.Err() != nil {
+				return
+			}
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if err == nil {
+				endpoint.failures = 0
+			}
+			p.setHealthyLocked(endpoint, err, time.Now())
+		}(endpoint)
+	}
+	wg.Wait()
+}
+
+// probeChannelEndpoint отправляет HEAD на адрес (или ChannelHealthPath того же сервера).
+// Любой ответ, кроме 5xx, означает, что адрес доступен.
+func probeChannelEndpoint(ctx context.Context, address string, timeout time.Duration) error {
+	target := address
+	if ChannelHealthPath != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return err
+		}
+		u.Path, u.RawQuery = ChannelHealthPath, ""
+		target = u.String()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := outboundHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("проверка %s: статус %s", target, resp.Status)
+	}
+	return nil
+}
+
+// RunChannelHealthChecks периодически проверяет адреса канального уровня до отмены ctx
+func RunChannelHealthChecks(ctx context.Context) {
+	if ChannelHealthInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ChannelHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if pool, err := channelPool(); err == nil {
+			pool.checkHealth(ctx, min(ChannelHealthInterval, HTTPTimeout))
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withChannelPool задает адреса и стратегию канального уровня на время теста
+func withChannelPool(t *testing.T, urls, strategy string) *endpointPool {
+	t.Helper()
+	savedURL, savedStrategy, savedLimiter := urlChannelLevel, ChannelStrategy, sendLimiter
+	t.Cleanup(func() {
+		urlChannelLevel, ChannelStrategy, sendLimiter = savedURL, savedStrategy, savedLimiter
+		setReadiness(readinessChannel, nil)
+	})
+	urlChannelLevel, ChannelStrategy, sendLimiter = urls, strategy, nil
+	pool, err := channelPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestEndpointPoolStrategies(t *testing.T) {
+	for _, value := range []string{"", "localhost:8081", "http://a/code;weight=0", "http://a/code;w=2", "http://a/code,http://a/code"} {
+		if _, err := newEndpointPool(value, strategyRoundRobin); err == nil {
+			t.Errorf("список %q должен быть отклонен", value)
+		}
+	}
+	if _, err := newEndpointPool("http://a/code", "random"); err == nil {
+		t.Error("неизвестная стратегия должна быть отклонена")
+	}
+
+	const urls = "http://a/code;weight=3, http://b/code"
+	now := time.Now()
+	picks := func(strategy string, n int, key func(i int) string) map[string]int {
+		pool, err := newEndpointPool(urls, strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			counts[pool.pick(key(i), nil, now).url]++
+		}
+		return counts
+	}
+	byIndex := func(i int) string { return string(rune('a' + i)) }
+
+	if counts := picks(strategyRoundRobin, 8, byIndex); counts["http://a/code"] != 4 || counts["http://b/code"] != 4 {
+		t.Errorf("round-robin: %v", counts)
+	}
+	if counts := picks(strategyWeighted, 8, byIndex); counts["http://a/code"] != 6 || counts["http://b/code"] != 2 {
+		t.Errorf("weighted: %v", counts)
+	}
+	if counts := picks(strategySticky, 8, func(int) string { return "sender_2024-05-21T02:34:48Z" }); len(counts) != 1 {
+		t.Errorf("sticky: сегменты одного сообщения на разных адресах: %v", counts)
+	}
+	if counts := picks(strategySticky, 64, byIndex); len(counts) != 2 {
+		t.Errorf("sticky: сообщения не распределены по адресам: %v", counts)
+	}
+
+	pool, _ := newEndpointPool(urls, strategyLeastInFlight)
+	pool.endpoints[0].inFlight.Store(2)
+	for i := 0; i < 3; i++ {
+		if got := pool.pick("", nil, now).url; got != "http://b/code" {
+			t.Errorf("least-inflight: выбран %s", got)
+		}
+	}
+	if got := pool.pick("", map[*channelEndpoint]bool{pool.endpoints[1]: true}, now).url; got != "http://a/code" {
+		t.Errorf("least-inflight без опробованного адреса: выбран %s", got)
+	}
+}
+
+func TestSendSegmentFailover(t *testing.T) {
+	withBreakerSettings(t, 0, time.Hour, 1)
+	var mu sync.Mutex
+	received := make(map[string][]int) // Адрес -> номера сегментов
+	var brokenRequests atomic.Int32
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var segment Segment
+		json.NewDecoder(r.Body).Decode(&segment)
+		mu.Lock()
+		received[segment.Sender] = append(received[segment.Sender], segment.SegmentNumber)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		brokenRequests.Add(1)
+		http.Error(w, "нет связи", http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	pool := withChannelPool(t, broken.URL+","+healthy.URL, strategySticky)
+	send := func(sender string) {
+		t.Helper()
+		body, _ := json.Marshal(SendRequest{Sender: sender, SendTime: time.Now(), Payload: strings.Repeat("x", 4*SegmentSize)})
+		w := httptest.NewRecorder()
+		HandleSend(w, httptest.NewRequest(http.MethodPost, "/send", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("отправка %s: %d %s", sender, w.Code, w.Body)
+		}
+	}
+
+	// Сегменты, не принятые неисправным адресом, передаются на исправный
+	for i := 0; i < 8; i++ {
+		send("failover-" + string(rune('a'+i)))
+	}
+	for sender, segments := range received {
+		if len(segments) != 4 {
+			t.Errorf("%s: получены сегменты %v", sender, segments)
+		}
+	}
+	if len(received) != 8 {
+		t.Fatalf("получено сообщений %d из 8", len(received))
+	}
+
+	// После ChannelUnhealthyAfter неудач адрес исключается до повторной проверки
+	if pool.endpoints[0].healthy {
+		t.Fatal("неисправный адрес не отмечен недоступным")
+	}
+	before := brokenRequests.Load()
+	send("failover-after")
+	if n := brokenRequests.Load() - before; n != 0 {
+		t.Errorf("на недоступный адрес отправлено %d сегментов", n)
+	}
+	if ready, failures := readinessReport(); !ready {
+		t.Errorf("пул с исправным адресом не готов: %v", failures)
+	}
+}
+
+func TestChannelHealthChecks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("проверка методом %s", r.Method)
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed) // Адрес отвечает, значит доступен
+	}))
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	pool := withChannelPool(t, server.URL+","+down.URL, strategyRoundRobin)
+	pool.checkHealth(context.Background(), time.Second)
+	if !pool.endpoints[0].healthy || pool.endpoints[1].healthy {
+		t.Fatalf("состояние адресов: %v, %v", pool.endpoints[0].healthy, pool.endpoints[1].healthy)
+	}
+	if ready, _ := readinessReport(); !ready {
+		t.Error("пул с доступным адресом не готов")
+	}
+
+	server.Close()
+	pool.checkHealth(context.Background(), time.Second)
+	if _, failures := readinessReport(); failures[readinessChannel] == "" {
+		t.Error("пул без доступных адресов должен быть не готов")
+	}
+}
+
+func TestChannelHealthPathWithChannelSim(t *testing.T) {
+	savedPath := ChannelHealthPath
+	t.Cleanup(func() { ChannelHealthPath = savedPath })
+	sim := httptest.NewServer(NewChannelSim(ChannelSimConfig{}).Handler())
+	defer sim.Close()
+
+	// HEAD на адрес приема сегментов отвечает 405: проверка без пути ничего не говорит о симуляторе
+	ChannelHealthPath = ""
+	if err := probeChannelEndpoint(context.Background(), sim.URL+"/code", time.Second); err != nil {
+		t.Fatalf("проверка без пути: %v", err)
+	}
+	ChannelHealthPath = "/healthz"
+	req, _ := http.NewRequest(http.MethodHead, sim.URL+ChannelHealthPath, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("HEAD %s: %s", ChannelHealthPath, resp.Status)
+	}
+
+	pool := withChannelPool(t, sim.URL+"/code", strategyRoundRobin)
+	pool.checkHealth(context.Background(), time.Second)
+	if !pool.endpoints[0].healthy {
+		t.Error("симулятор с путем проверки отмечен недоступным")
+	}
+}
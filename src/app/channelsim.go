@@ -0,0 +1,261 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Параметры симулятора канального уровня
+type ChannelSimConfig struct {
+	TargetURL    string        // Адрес /transfer принимающего транспортного уровня
+	LossProb     float64       // Вероятность потери сегмента
+	CorruptProb  float64       // Вероятность инверсии одного бита полезной нагрузки
+	DupProb      float64       // Вероятность дублирования сегмента
+	ReorderProb  float64       // Вероятность дополнительной задержки, меняющей порядок сегментов
+	ReorderDelay time.Duration // Дополнительная задержка переупорядоченного сегмента
+	DelayDist    string        // Распределение задержки: none, fixed, uniform, normal, exp
+	DelayMean    time.Duration // Средняя задержка
+	DelayJitter  time.Duration // Разброс (uniform - полуширина, normal - стандартное отклонение)
+	Seed         int64         // Зерно генератора для воспроизводимых экспериментов
+}
+
+// Счетчики симулятора канального уровня
+type ChannelSimStats struct {
+	Received   int `json:"received"`
+	Lost       int `json:"lost"`
+	Corrupted  int `json:"corrupted"`
+	Duplicated int `json:"duplicated"`
+	Reordered  int `json:"reordered"`
+	Forwarded  int `json:"forwarded"`
+	Failed     int `json:"failed"` // Ошибки пересылки на /transfer
+}
+
+// ChannelSim - Симулятор канального уровня: принимает сегменты, отправляемые sendSegment,
+// и пересылает их на /transfer с потерями, искажениями, дублированием, переупорядочиванием и задержками.
+// Решения принимаются в порядке поступления сегментов одним генератором, поэтому при одинаковом
+// зерне и последовательной отправке эксперимент воспроизводим.
+type ChannelSim struct {
+	config  ChannelSimConfig
+	client  *http.Client
+	pending sync.WaitGroup
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	stats ChannelSimStats
+}
+
+// NewChannelSim создает симулятор канального уровня
+func NewChannelSim(config ChannelSimConfig) *ChannelSim {
+	return &ChannelSim{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		rng:    rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Handler возвращает обработчики симулятора: POST /code принимает сегмент, GET /stats - счетчики,
+// GET и HEAD /healthz - проверка доступности для TRANSPORT_CHANNEL_HEALTH_PATH
+func (c *ChannelSim) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /code", c.handleSegment)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Stats())
+	})
+	return mux
+}
+
+// Stats возвращает текущие счетчики
+func (c *ChannelSim) Stats() ChannelSimStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Wait ожидает завершения всех запланированных пересылок
+func (c *ChannelSim) Wait() {
+	c.pending.Wait()
+}
+
+// delivery - Запланированная пересылка одного экземпляра сегмента
+type delivery struct {
+	body  []byte
+	delay time.Duration
+}
+
+func (c *ChannelSim) handleSegment(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Ошибка чтения тела", http.StatusBadRequest)
+		return
+	}
+
+	var segment Segment
+	if err := json.Unmarshal(body, &segment); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "некорректный сегмент"})
+		return
+	}
+
+	// Канальный уровень подтверждает прием сразу, пересылка выполняется асинхронно
+	for _, d := range c.plan(segment) {
+		c.pending.Add(1)
+		go c.forward(d)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// plan применяет к сегменту модель канала и возвращает список пересылок
+func (c *ChannelSim) plan(segment Segment) []delivery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Received++
+	if c.rng.Float64() < c.config.LossProb {
+		c.stats.Lost++
+		log.Printf("[channel-sim] Сегмент %d/%d от '%s' потерян", segment.SegmentNumber, segment.TotalSegments, segment.Sender)
+		return nil
+	}
+
+	if len(segment.SegmentPayload) > 0 && c.rng.Float64() < c.config.CorruptProb {
+		payload := []byte(segment.SegmentPayload)
+		bit := c.rng.Intn(len(payload) * 8)
+		payload[bit/8] ^= 1 << (bit % 8)
+		segment.SegmentPayload = string(payload)
+		c.stats.Corrupted++
+		log.Printf("[channel-sim] Сегмент %d/%d от '%s' искажен (бит %d)", segment.SegmentNumber, segment.TotalSegments, segment.Sender, bit)
+	}
+
+	body, err := json.Marshal(segment)
+	if err != nil {
+		c.stats.Failed++
+		return nil
+	}
+
+	copies := 1
+	if c.rng.Float64() < c.config.DupProb {
+		copies = 2
+		c.stats.Duplicated++
+	}
+
+	deliveries := make([]delivery, 0, copies)
+	for i := 0; i < copies; i++ {
+		delay := c.sampleDelay()
+		if c.rng.Float64() < c.config.ReorderProb {
+			delay += c.config.ReorderDelay
+			c.stats.Reordered++
+		}
+		deliveries = append(deliveries, delivery{body: body, delay: delay})
+	}
+	return deliveries
+}
+
+// sampleDelay возвращает задержку согласно настроенному распределению. Вызывается под мьютексом.
+func (c *ChannelSim) sampleDelay() time.Duration {
+	mean, jitter := float64(c.config.DelayMean), float64(c.config.DelayJitter)
+	var delay float64
+	switch c.config.DelayDist {
+	case "fixed":
+		delay = mean
+	case "uniform":
+		delay = mean - jitter + c.rng.Float64()*2*jitter
+	case "normal":
+		delay = mean + c.rng.NormFloat64()*jitter
+	case "exp":
+		delay = c.rng.ExpFloat64() * mean
+	}
+	return time.Duration(max(delay, 0))
+}
+
+func (c *ChannelSim) forward(d delivery) {
+	defer c.pending.Done()
+	time.Sleep(d.delay)
+
+	resp, err := c.client.Post(c.config.TargetURL, "application/json", bytes.NewReader(d.body))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.stats.Failed++
+		log.Printf("[channel-sim] Ошибка пересылки на %s: %v", c.config.TargetURL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.stats.Failed++
+		log.Printf("[channel-sim] %s ответил статусом %s", c.config.TargetURL, resp.Status)
+		return
+	}
+	c.stats.Forwarded++
+}
+
+// RunChannelSim - Команда channel-sim: запускает симулятор канального уровня.
+func RunChannelSim(args []string) error {
+	fs := flag.NewFlagSet("channel-sim", flag.ContinueOnError)
+	listen := fs.String("listen", ":8081", "адрес HTTP сервера симулятора")
+	config := ChannelSimConfig{}
+	fs.StringVar(&config.TargetURL, "target", "http://localhost:8080/transfer", "адрес /transfer принимающего транспортного уровня")
+	fs.Float64Var(&config.LossProb, "loss", 0, "вероятность потери сегмента")
+	fs.Float64Var(&config.CorruptProb, "corrupt", 0, "вероятность инверсии бита полезной нагрузки")
+	fs.Float64Var(&config.DupProb, "dup", 0, "вероятность дублирования сегмента")
+	fs.Float64Var(&config.ReorderProb, "reorder", 0, "вероятность переупорядочивания сегмента")
+	fs.DurationVar(&config.ReorderDelay, "reorder-delay", 500*time.Millisecond, "дополнительная задержка переупорядоченного сегмента")
+	fs.StringVar(&config.DelayDist, "delay", "none", "распределение задержки: none, fixed, uniform, normal, exp")
+	fs.DurationVar(&config.DelayMean, "delay-mean", 0, "средняя задержка")
+	fs.DurationVar(&config.DelayJitter, "delay-jitter", 0, "разброс задержки")
+	fs.Int64Var(&config.Seed, "seed", 1, "зерно генератора случайных чисел")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch config.DelayDist {
+	case "none", "fixed", "uniform", "normal", "exp":
+	default:
+		return fmt.Errorf("неизвестное распределение задержки: %s", config.DelayDist)
+	}
+
+	sim := NewChannelSim(config)
+	srv := &http.Server{Addr: *listen, Handler: sim.Handler()}
+	log.Printf("[channel-sim] Запуск на %s/code, пересылка на %s, параметры: %+v", *listen, config.TargetURL, config)
+
+	return serveUntilSignal(srv, func() {
+		sim.Wait()
+		log.Printf("[channel-sim] Итоги: %+v", sim.Stats())
+	})
+}
+
+// serveUntilSignal запускает HTTP сервер вспомогательной команды до получения сигнала завершения,
+// после чего останавливает сервер и вызывает onShutdown.
+func serveUntilSignal(srv *http.Server, onShutdown func()) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if onShutdown != nil {
+		onShutdown()
+	}
+	return err
+}
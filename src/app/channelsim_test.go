@@ -0,0 +1,163 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChannelSimPlan(t *testing.T) {
+	segment := testSegment("alice", 1, 1, "привет")
+	tests := []struct {
+		name       string
+		config     ChannelSimConfig
+		deliveries int
+		delays     []time.Duration // nil - не проверяются
+		corrupted  bool
+		stats      ChannelSimStats
+	}{
+		{
+			name:       "без искажений",
+			config:     ChannelSimConfig{DelayDist: "none"},
+			deliveries: 1,
+			delays:     []time.Duration{0},
+			stats:      ChannelSimStats{Received: 1},
+		},
+		{
+			name:   "потеря",
+			config: ChannelSimConfig{LossProb: 1, CorruptProb: 1, DupProb: 1},
+			stats:  ChannelSimStats{Received: 1, Lost: 1},
+		},
+		{
+			name:       "искажение",
+			config:     ChannelSimConfig{CorruptProb: 1},
+			deliveries: 1,
+			corrupted:  true,
+			stats:      ChannelSimStats{Received: 1, Corrupted: 1},
+		},
+		{
+			name:       "дублирование",
+			config:     ChannelSimConfig{DupProb: 1},
+			deliveries: 2,
+			stats:      ChannelSimStats{Received: 1, Duplicated: 1},
+		},
+		{
+			name:       "переупорядочивание каждой копии",
+			config:     ChannelSimConfig{DupProb: 1, ReorderProb: 1, ReorderDelay: time.Second, DelayDist: "fixed", DelayMean: 10 * time.Millisecond},
+			deliveries: 2,
+			delays:     []time.Duration{1010 * time.Millisecond, 1010 * time.Millisecond},
+			stats:      ChannelSimStats{Received: 1, Duplicated: 1, Reordered: 2},
+		},
+		{
+			name:       "отрицательная задержка ограничена нулем",
+			config:     ChannelSimConfig{DelayDist: "fixed", DelayMean: -time.Second},
+			deliveries: 1,
+			delays:     []time.Duration{0},
+			stats:      ChannelSimStats{Received: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := NewChannelSim(tt.config)
+			deliveries := sim.plan(segment)
+			if len(deliveries) != tt.deliveries {
+				t.Fatalf("пересылок %d, ожидалось %d", len(deliveries), tt.deliveries)
+			}
+			for i, d := range deliveries {
+				var got Segment
+				if err := json.Unmarshal(d.body, &got); err != nil {
+					t.Fatal(err)
+				}
+				if (got.SegmentPayload != segment.SegmentPayload) != tt.corrupted {
+					t.Errorf("пересылка %d: нагрузка %q", i, got.SegmentPayload)
+				}
+				if tt.delays != nil && d.delay != tt.delays[i] {
+					t.Errorf("пересылка %d: задержка %s, ожидалась %s", i, d.delay, tt.delays[i])
+				}
+			}
+			if got := sim.Stats(); got != tt.stats {
+				t.Errorf("счетчики %+v, ожидалось %+v", got, tt.stats)
+			}
+		})
+	}
+}
+
+func TestChannelSimSeedReproducible(t *testing.T) {
+	config := ChannelSimConfig{
+		LossProb: 0.2, CorruptProb: 0.2, DupProb: 0.2, ReorderProb: 0.2, ReorderDelay: time.Second,
+		DelayDist: "normal", DelayMean: 50 * time.Millisecond, DelayJitter: 20 * time.Millisecond, Seed: 42,
+	}
+	run := func(seed int64) ([]delivery, ChannelSimStats) {
+		config.Seed = seed
+		sim := NewChannelSim(config)
+		var all []delivery
+		for i := 1; i <= 50; i++ {
+			all = append(all, sim.plan(testSegment("alice", i, 50, "полезная нагрузка"))...)
+		}
+		return all, sim.Stats()
+	}
+
+	first, firstStats := run(42)
+	second, secondStats := run(42)
+	if !reflect.DeepEqual(first, second) || firstStats != secondStats {
+		t.Fatalf("при одинаковом зерне результаты различаются: %+v и %+v", firstStats, secondStats)
+	}
+	if firstStats.Lost == 0 || firstStats.Corrupted == 0 || firstStats.Duplicated == 0 || firstStats.Reordered == 0 {
+		t.Errorf("за 50 сегментов сработали не все искажения: %+v", firstStats)
+	}
+	if _, otherStats := run(7); otherStats == firstStats {
+		t.Errorf("при другом зерне счетчики совпали: %+v", otherStats)
+	}
+}
+
+func TestChannelSimForward(t *testing.T) {
+	var calls atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 2 {
+			http.Error(w, "ошибка", http.StatusInternalServerError)
+		}
+	}))
+	defer target.Close()
+
+	sim := NewChannelSim(ChannelSimConfig{TargetURL: target.URL, DupProb: 1})
+	server := httptest.NewServer(sim.Handler())
+	defer server.Close()
+
+	body, _ := json.Marshal(testSegment("alice", 1, 1, "привет"))
+	resp, err := http.Post(server.URL+"/code", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("сегмент не принят: %s", resp.Status)
+	}
+	resp, err = http.Post(server.URL+"/code", "application/json", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("некорректный сегмент: %s", resp.Status)
+	}
+
+	sim.Wait()
+	want := ChannelSimStats{Received: 1, Duplicated: 1, Forwarded: 1, Failed: 1}
+	if got := sim.Stats(); got != want {
+		t.Errorf("счетчики %+v, ожидалось %+v", got, want)
+	}
+}
+
+func TestRunChannelSimArguments(t *testing.T) {
+	for _, args := range [][]string{{"-delay", "pareto"}, {"-loss", "x"}, {"-unknown"}} {
+		if err := RunChannelSim(args); err == nil {
+			t.Errorf("RunChannelSim(%q): ожидалась ошибка", args)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// cliClient - HTTP клиент команд командной строки
+var cliClient = &http.Client{Timeout: 30 * time.Second}
+
+// cliDo выполняет запрос и печатает ответ; статус, отличный от 2xx, возвращается как ошибка
+func cliDo(req *http.Request) error {
+	resp, err := cliClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, body, "", "  ") == nil {
+		body = pretty.Bytes()
+	}
+	fmt.Printf("%s\n%s\n", resp.Status, strings.TrimSpace(string(body)))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("сервер ответил %s", resp.Status)
+	}
+	return nil
+}
+
+func cliPost(target string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return cliDo(req)
+}
+
+// parseSendTime разбирает время отправки; пустое значение - текущее время
+func parseSendTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now().UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// RunSendCommand - Команда send: отправка сообщения на /send из stdin или файла.
+func RunSendCommand(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	target := fs.String("target", TransportURL, "базовый адрес транспортного уровня")
+	sender := fs.String("sender", os.Getenv("USER"), "отправитель")
+	recipient := fs.String("recipient", "", "получатель (необязательно, для фильтрации подписок)")
+	file := fs.String("file", "", "файл с текстом сообщения (по умолчанию stdin)")
+	sendTime := fs.String("time", "", "время отправки в RFC3339 (по умолчанию текущее)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var data []byte
+	var err error
+	if *file != "" {
+		data, err = os.ReadFile(*file)
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка чтения сообщения: %v", err)
+	}
+
+	t, err := parseSendTime(*sendTime)
+	if err != nil {
+		return fmt.Errorf("некорректное время отправки: %v", err)
+	}
+	body, err := json.Marshal(SendRequest{Sender: *sender, Recipient: *recipient, SendTime: t, Payload: strings.TrimRight(string(data), "\n")})
+	if err != nil {
+		return err
+	}
+	return cliPost(*target+"/send", body)
+}
+
+// RunTransferCommand - Команда transfer: отправка одного сегмента (в том числе некорректного) на /transfer.
+func RunTransferCommand(args []string) error {
+	fs := flag.NewFlagSet("transfer", flag.ContinueOnError)
+	target := fs.String("target", TransportURL, "базовый адрес транспортного уровня")
+	sender := fs.String("sender", os.Getenv("USER"), "отправитель")
+	number := fs.Int("n", 1, "номер сегмента")
+	total := fs.Int("total", 1, "общее количество сегментов")
+	payload := fs.String("payload", "", "полезная нагрузка сегмента")
+	sendTime := fs.String("time", "", "время отправки в RFC3339 (по умолчанию текущее)")
+	raw := fs.String("raw", "", "отправить тело запроса как есть (для некорректных сегментов); \"-\" - из stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *raw != "" {
+		body := []byte(*raw)
+		if *raw == "-" {
+			var err error
+			if body, err = io.ReadAll(os.Stdin); err != nil {
+				return err
+			}
+		}
+		return cliPost(*target+"/transfer", body)
+	}
+
+	t, err := parseSendTime(*sendTime)
+	if err != nil {
+		return fmt.Errorf("некорректное время отправки: %v", err)
+	}
+	body, err := json.Marshal(Segment{
+		SegmentNumber:  *number,
+		TotalSegments:  *total,
+		Sender:         *sender,
+		SendTime:       t,
+		SegmentPayload: *payload,
+	})
+	if err != nil {
+		return err
+	}
+	return cliPost(*target+"/transfer", body)
+}
+
+// RunTailCommand - Команда tail: чтение топика сегментов с форматированным выводом.
+func RunTailCommand(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	topic := fs.String("topic", KafkaTopic, "топик Kafka")
+	fromBeginning := fs.Bool("from-beginning", false, "читать топик с начала")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	offsetReset := "latest"
+	if *fromBeginning {
+		offsetReset = "earliest"
+	}
+	// Отдельная группа без коммита оффсетов не влияет на горутину сборки
+	config, err := kafkaConfigMap(confluent.ConfigMap{
+		"group.id":           fmt.Sprintf("segment-tail-%d", os.Getpid()),
+		"auto.offset.reset":  offsetReset,
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		return err
+	}
+	consumer, err := confluent.NewConsumer(config)
+	if err != nil {
+		return fmt.Errorf("не удалось создать Kafka consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.SubscribeTopics([]string{*topic}, nil); err != nil {
+		return fmt.Errorf("не удалось подписаться на топик %s: %v", *topic, err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case <-signals:
+			return nil
+		default:
+		}
+
+		msg, err := consumer.ReadMessage(200 * time.Millisecond)
+		if err != nil {
+			var kerr confluent.Error
+			if errors.As(err, &kerr) && kerr.IsFatal() {
+				return err
+			}
+			continue
+		}
+
+		prefix := fmt.Sprintf("%s [%d:%v]", msg.Timestamp.Format("15:04:05.000"), msg.TopicPartition.Partition, msg.TopicPartition.Offset)
+		var segment Segment
+		if err := json.Unmarshal(msg.Value, &segment); err != nil {
+			fmt.Printf("%s НЕКОРРЕКТНЫЙ (%v): %q\n", prefix, err, msg.Value)
+			continue
+		}
+		fmt.Printf("%s %s @ %s  %d/%d  %q\n", prefix, segment.Sender, segment.SendTime.Format(time.RFC3339Nano),
+			segment.SegmentNumber, segment.TotalSegments, segment.SegmentPayload)
+	}
+}
+
+// RunInspectCommand - Команда inspect: запросы к admin API.
+func RunInspectCommand(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	target := fs.String("target", TransportURL, "базовый адрес транспортного уровня")
+	token := fs.String("token", AdminToken, "токен admin API")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "использование: inspect [флаги] list | history | get KEY | complete KEY | expire KEY | drop KEY")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("не указана операция")
+	}
+
+	op := fs.Arg(0)
+	method, path := http.MethodGet, ""
+	switch op {
+	case "list":
+		path = "/admin/messages"
+	case "history":
+		path = "/admin/history"
+	case "get", "complete", "expire", "drop":
+		if fs.NArg() < 2 {
+			return fmt.Errorf("для %s нужен ключ сообщения", op)
+		}
+		path = "/admin/messages/" + url.PathEscape(fs.Arg(1))
+		switch op {
+		case "complete", "expire":
+			method, path = http.MethodPost, path+"/"+op
+		case "drop":
+			method = http.MethodDelete
+		}
+	default:
+		fs.Usage()
+		return fmt.Errorf("неизвестная операция: %s", op)
+	}
+
+	req, err := http.NewRequest(method, *target+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	return cliDo(req)
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cliRequest - Запрос, полученный тестовым сервером от команды
+type cliRequest struct {
+	method, path, auth string
+	body               []byte
+}
+
+// cliServer запускает сервер, запоминающий запросы команд и отвечающий status
+func cliServer(t *testing.T, status int) (*httptest.Server, *[]cliRequest) {
+	t.Helper()
+	var requests []cliRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, cliRequest{method: r.Method, path: r.URL.EscapedPath(), auth: r.Header.Get("Authorization"), body: body})
+		w.WriteHeader(status)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRunSendCommand(t *testing.T) {
+	server, requests := cliServer(t, http.StatusOK)
+	file := filepath.Join(t.TempDir(), "message.txt")
+	if err := os.WriteFile(file, []byte("привет\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := RunSendCommand([]string{"-target", server.URL, "-sender", "alice", "-recipient", "bob", "-file", file, "-time", "2024-05-21T02:34:48Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 || (*requests)[0].path != "/send" {
+		t.Fatalf("запросы: %+v", *requests)
+	}
+	var got SendRequest
+	if err := json.Unmarshal((*requests)[0].body, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := SendRequest{Sender: "alice", Recipient: "bob", SendTime: time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC), Payload: "привет"}
+	if got.Sender != want.Sender || got.Recipient != want.Recipient || !got.SendTime.Equal(want.SendTime) || got.Payload != want.Payload {
+		t.Errorf("отправлено %+v, ожидалось %+v", got, want)
+	}
+
+	for _, args := range [][]string{
+		{"-target", server.URL, "-file", file, "-time", "вчера"},
+		{"-target", server.URL, "-file", filepath.Join(t.TempDir(), "missing.txt")},
+		{"-unknown"},
+	} {
+		if err := RunSendCommand(args); err == nil {
+			t.Errorf("RunSendCommand(%q): ожидалась ошибка", args)
+		}
+	}
+	if len(*requests) != 1 {
+		t.Errorf("при ошибке аргументов отправлено %d запросов", len(*requests)-1)
+	}
+}
+
+func TestRunTransferCommand(t *testing.T) {
+	server, requests := cliServer(t, http.StatusOK)
+
+	err := RunTransferCommand([]string{"-target", server.URL, "-sender", "bob", "-n", "2", "-total", "3", "-payload", "вет", "-time", "2024-05-21T02:34:48Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var segment Segment
+	if err := json.Unmarshal((*requests)[0].body, &segment); err != nil {
+		t.Fatal(err)
+	}
+	if (*requests)[0].path != "/transfer" || segment.Sender != "bob" || segment.SegmentNumber != 2 || segment.TotalSegments != 3 || segment.SegmentPayload != "вет" {
+		t.Errorf("отправлен сегмент %+v на %s", segment, (*requests)[0].path)
+	}
+
+	if err := RunTransferCommand([]string{"-target", server.URL, "-raw", `{"segment_number":`}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string((*requests)[1].body); got != `{"segment_number":` {
+		t.Errorf("тело -raw изменено: %q", got)
+	}
+
+	rejecting, _ := cliServer(t, http.StatusBadRequest)
+	if err := RunTransferCommand([]string{"-target", rejecting.URL, "-payload", "x"}); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("ответ 400 не возвращен ошибкой: %v", err)
+	}
+}
+
+func TestRunInspectCommand(t *testing.T) {
+	tests := []struct {
+		args    []string
+		method  string
+		path    string
+		wantErr bool
+	}{
+		{args: []string{"list"}, method: http.MethodGet, path: "/admin/messages"},
+		{args: []string{"history"}, method: http.MethodGet, path: "/admin/history"},
+		{args: []string{"get", "alice/1"}, method: http.MethodGet, path: "/admin/messages/alice%2F1"},
+		{args: []string{"complete", "k"}, method: http.MethodPost, path: "/admin/messages/k/complete"},
+		{args: []string{"expire", "k"}, method: http.MethodPost, path: "/admin/messages/k/expire"},
+		{args: []string{"drop", "k"}, method: http.MethodDelete, path: "/admin/messages/k"},
+		{args: []string{"get"}, wantErr: true},
+		{args: []string{"purge"}, wantErr: true},
+		{args: []string{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		server, requests := cliServer(t, http.StatusOK)
+		err := RunInspectCommand(append([]string{"-target", server.URL, "-token", "t0ken"}, tt.args...))
+		if tt.wantErr {
+			if err == nil || len(*requests) != 0 {
+				t.Errorf("inspect %q: ошибка %v, запросов %d", tt.args, err, len(*requests))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("inspect %q: %v", tt.args, err)
+			continue
+		}
+		got := (*requests)[0]
+		if got.method != tt.method || got.path != tt.path || got.auth != "Bearer t0ken" {
+			t.Errorf("inspect %q: %s %s (%s), ожидалось %s %s", tt.args, got.method, got.path, got.auth, tt.method, tt.path)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package app
 
-import "time"
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
 
 // TODO: Перенести в конфигурацию (env, файл и т.д.)
 
@@ -8,21 +13,269 @@ import "time"
 const (
 	// SegmentSize - Максимальный размер сегмента сообщения в байтах.
 	SegmentSize = 140
-	// BuildInterval - Интервал времени для периодической проверки незавершенных сообщений на таймаут или полную сборку.
-	BuildInterval = 1 * time.Second
 	// MaxInactivityCycles - Максимальный интервал времени без поступления новых сегментов для сообщения
 	// прежде чем оно будет помечено как несобранное (ошибка).
 	MaxInactivityCycles = 3 * time.Second
-	// urlChannelLevel - Адрес эндпоинта канального уровня для отправки сегментов (используется в качестве заглушки).
-	urlChannelLevel = "http://10.147.17.217:8081/code"
-	// urlApplLevel - Адрес эндпоинта прикладного уровня для передачи собранных сообщений (используется в качестве заглушки).
-	urlApplLevel = "http://10.147.17.233:8002/receive"
+)
+
+// --- Адреса соседних уровней ---
+var (
+	// urlChannelLevel - Адреса эндпоинтов канального уровня для отправки сегментов через запятую,
+	// с необязательным весом: "http://a:8081/code;weight=3,http://b:8081/code".
+	// Для локальной проверки можно указать симулятор: TRANSPORT_CHANNEL_URL=http://localhost:8081/code
+	urlChannelLevel = envString("TRANSPORT_CHANNEL_URL", "http://10.147.17.217:8081/code")
+	// urlApplLevel - Адрес эндпоинта прикладного уровня для передачи собранных сообщений.
+	urlApplLevel = envString("TRANSPORT_APPL_URL", "http://10.147.17.233:8002/receive")
+	// TransportURL - Базовый адрес транспортного уровня для команд командной строки (send, transfer, inspect, bench).
+	TransportURL = envString("TRANSPORT_URL", "http://localhost:8080")
 )
 
 // --- Конфигурация Kafka ---
 const (
-	// KafkaAddr - Адрес брокера(ов) Kafka.
-	KafkaAddr = "localhost:29092"
 	// KafkaTopic - Топик Kafka для обмена сегментами сообщений.
 	KafkaTopic = "segments"
 )
+
+// --- Подключение к Kafka ---
+var (
+	// KafkaAddr - Адрес брокера(ов) Kafka.
+	KafkaAddr = envString("TRANSPORT_KAFKA_ADDR", "localhost:29092")
+	// KafkaTLS - Подключаться по TLS (включается также при заданном KafkaTLSCA или KafkaTLSCert).
+	KafkaTLS = envBool("TRANSPORT_KAFKA_TLS", false)
+	// KafkaTLSCA - PEM файл корневых сертификатов брокера (пусто - системные).
+	KafkaTLSCA = envString("TRANSPORT_KAFKA_TLS_CA", "")
+	// KafkaTLSCert, KafkaTLSKey - PEM файлы клиентского сертификата и ключа для mTLS.
+	KafkaTLSCert = envString("TRANSPORT_KAFKA_TLS_CERT", "")
+	KafkaTLSKey  = envString("TRANSPORT_KAFKA_TLS_KEY", "")
+	// KafkaSASLMechanism - Механизм SASL: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 (пусто - без аутентификации).
+	KafkaSASLMechanism = envString("TRANSPORT_KAFKA_SASL_MECHANISM", "")
+	// KafkaSASLUsername, KafkaSASLPassword - Учетные данные SASL.
+	KafkaSASLUsername = envString("TRANSPORT_KAFKA_SASL_USERNAME", "")
+	KafkaSASLPassword = envString("TRANSPORT_KAFKA_SASL_PASSWORD", "")
+)
+
+// --- Параметры топиков Kafka, проверяемых при запуске ---
+var (
+	// KafkaAutoCreateTopics - Создавать отсутствующие топики при запуске.
+	KafkaAutoCreateTopics = envBool("TRANSPORT_KAFKA_AUTO_CREATE", true)
+	// KafkaTopicPartitions - Количество разделов создаваемых топиков.
+	KafkaTopicPartitions = envInt("TRANSPORT_KAFKA_PARTITIONS", 1)
+	// KafkaReplicationFactor - Фактор репликации создаваемых топиков.
+	KafkaReplicationFactor = envInt("TRANSPORT_KAFKA_REPLICATION_FACTOR", 1)
+	// KafkaRetention - Время хранения записей создаваемых топиков (0 - настройка брокера).
+	KafkaRetention = envDuration("TRANSPORT_KAFKA_RETENTION", 0)
+	// ReassemblyConsumers - Количество экземпляров сборки в группе; топику сегментов нужно не меньше разделов.
+	ReassemblyConsumers = envInt("TRANSPORT_REASSEMBLY_CONSUMERS", 1)
+)
+
+// --- Параметры, задаваемые через переменные окружения ---
+var (
+	// AdminToken - Токен доступа к административному API (/admin/...). Пустое значение отключает admin API.
+	AdminToken = envString("TRANSPORT_ADMIN_TOKEN", "")
+	// AdminHistorySize - Количество последних собранных/несобранных сообщений, хранимых для admin API.
+	AdminHistorySize = envInt("TRANSPORT_ADMIN_HISTORY_SIZE", 100)
+	// DrainMode - Обработка незавершенных сообщений при остановке: "notify" (уведомление об ошибке) или "persist" (сохранение в файл).
+	DrainMode = envString("TRANSPORT_DRAIN_MODE", drainModeNotify)
+	// DrainStateFile - Файл для сохранения незавершенных сообщений в режиме "persist".
+	DrainStateFile = envString("TRANSPORT_DRAIN_STATE_FILE", "inflight_state.json")
+	// DrainTimeout - Максимальное время ожидания незавершенных доставок на прикладной уровень при остановке.
+	DrainTimeout = envDuration("TRANSPORT_DRAIN_TIMEOUT", 10*time.Second)
+	// DLQTopic - Топик Kafka для отклоненных сегментов (dead-letter queue). Пустое значение отключает публикацию.
+	DLQTopic = envString("TRANSPORT_DLQ_TOPIC", "segments-dlq")
+	// DLQTimeout - Максимальное время публикации одной записи в DLQ; на это время останавливается цикл сборки.
+	DLQTimeout = envDuration("TRANSPORT_DLQ_TIMEOUT", 3*time.Second)
+	// DLQRetryBackoff - Пауза раздела перед повторной публикацией записи, которую не удалось опубликовать в DLQ.
+	DLQRetryBackoff = envDuration("TRANSPORT_DLQ_RETRY_BACKOFF", 5*time.Second)
+	// ReassemblyShards - Количество шардов движка сборки сообщений.
+	ReassemblyShards = envInt("TRANSPORT_REASSEMBLY_SHARDS", 16)
+)
+
+// --- Лимиты памяти сборки сообщений (0 - без ограничения) ---
+var (
+	// MaxSegmentsPerMessage - Максимальное количество сегментов в одном сообщении.
+	MaxSegmentsPerMessage = envInt("TRANSPORT_MAX_SEGMENTS_PER_MESSAGE", 1000)
+	// MaxMessageBytes - Максимальный размер полезной нагрузки одного сообщения в байтах.
+	MaxMessageBytes = envInt("TRANSPORT_MAX_MESSAGE_BYTES", 1<<20)
+	// MaxInFlightMessages - Максимальное количество одновременно собираемых сообщений.
+	MaxInFlightMessages = envInt("TRANSPORT_MAX_INFLIGHT_MESSAGES", 10000)
+	// MaxInFlightPerSender - Максимальное количество одновременно собираемых сообщений одного отправителя.
+	MaxInFlightPerSender = envInt("TRANSPORT_MAX_INFLIGHT_PER_SENDER", 100)
+	// MaxBufferedBytes - Максимальный суммарный объем буферизованной полезной нагрузки в байтах.
+	MaxBufferedBytes = int64(envInt("TRANSPORT_MAX_BUFFERED_BYTES", 64<<20))
+)
+
+// --- Отбрасывание повторных сегментов (0 - без кэша) ---
+var (
+	// DedupMessages - Количество ключей недавно завершенных сообщений, поздние сегменты которых отбрасываются.
+	DedupMessages = envInt("TRANSPORT_DEDUP_MESSAGES", 10000)
+	// DedupSegments - Количество недавно принятых /transfer сегментов, повторы которых не записываются в Kafka.
+	DedupSegments = envInt("TRANSPORT_DEDUP_SEGMENTS", 100000)
+	// DedupTTL - Время хранения записей кэша повторов.
+	DedupTTL = envDuration("TRANSPORT_DEDUP_TTL", 10*time.Minute)
+)
+
+// --- Подписчики WebSocket (/ws) ---
+var (
+	// WSSendBuffer - Количество сообщений в буфере отправки одного подписчика; при переполнении подписчик отключается.
+	WSSendBuffer = envInt("TRANSPORT_WS_SEND_BUFFER", 64)
+	// StreamsToken - Токен доступа к /ws и /events (заголовок Authorization: Bearer или параметр token).
+	// Пустое значение отключает потоки собранных сообщений.
+	StreamsToken = envString("TRANSPORT_STREAMS_TOKEN", "")
+	// WSAllowedOrigins - Разрешенные Origin браузерных клиентов через запятую ("*" - любые); свой хост разрешен всегда.
+	WSAllowedOrigins = envString("TRANSPORT_WS_ORIGINS", "")
+)
+
+// --- Поток событий (/events) ---
+var (
+	// EventLogSize - Количество последних событий, доступных для возобновления потока по Last-Event-ID.
+	EventLogSize = envInt("TRANSPORT_EVENT_LOG_SIZE", 1000)
+	// EventsSegments - Публиковать события о каждом полученном сегменте.
+	EventsSegments = envBool("TRANSPORT_EVENTS_SEGMENTS", false)
+)
+
+// --- Подписки webhook (/subscriptions) ---
+var (
+	// SubscriptionsFile - Файл, в котором сохраняются подписки.
+	SubscriptionsFile = envString("TRANSPORT_SUBSCRIPTIONS_FILE", "subscriptions.json")
+	// SubscriptionsToken - Токен доступа к /subscriptions. Пустое значение отключает API подписок.
+	SubscriptionsToken = envString("TRANSPORT_SUBSCRIPTIONS_TOKEN", "")
+	// WebhookAllowedHosts - Разрешенные адреса webhook через запятую: host или host:port ("*" - любые).
+	// Пустое значение - только сервер TRANSPORT_APPL_URL.
+	WebhookAllowedHosts = envString("TRANSPORT_WEBHOOK_ALLOWED_HOSTS", "")
+	// WebhookMaxFailures - Количество неудачных попыток доставки подряд, после которого подписка отключается (0 - не отключать).
+	WebhookMaxFailures = envInt("TRANSPORT_WEBHOOK_MAX_FAILURES", 10)
+)
+
+// --- Квитанции о доставке ---
+var (
+	// ReceiptRetention - Время хранения статусов доставки отправленных сообщений.
+	ReceiptRetention = envDuration("TRANSPORT_RECEIPT_RETENTION", time.Hour)
+	// ReceiptMaxMessages - Максимальное количество хранимых статусов доставки.
+	ReceiptMaxMessages = envInt("TRANSPORT_RECEIPT_MAX_MESSAGES", 10000)
+	// ReceiptCallbackURL - Адрес прикладного уровня для уведомлений о квитанциях (пусто - без уведомлений).
+	ReceiptCallbackURL = envString("TRANSPORT_RECEIPT_CALLBACK_URL", "")
+)
+
+// --- Асинхронная отправка (/send?async=true) ---
+var (
+	// SendAsyncDefault - Отправлять асинхронно без явного запроса клиента.
+	SendAsyncDefault = envBool("TRANSPORT_SEND_ASYNC", false)
+	// SendWorkers - Количество воркеров асинхронной отправки и одновременно передаваемых ими сегментов.
+	SendWorkers = envInt("TRANSPORT_SEND_WORKERS", 8)
+	// SendQueueSize - Размер очереди асинхронной отправки; при заполнении /send отвечает 503.
+	SendQueueSize = envInt("TRANSPORT_SEND_QUEUE", 1000)
+	// SendRetry - Политика повторной передачи сегмента канальному уровню.
+	SendRetry = envRetryPolicy("TRANSPORT_SEND_RETRY", "attempts=3,backoff=500ms,max_backoff=5s")
+	// SendJobRetention - Время хранения результатов асинхронной отправки.
+	SendJobRetention = envDuration("TRANSPORT_SEND_RETENTION", time.Hour)
+)
+
+// --- Исходящие HTTP запросы (канальный и прикладной уровни, webhook) ---
+var (
+	// HTTPTimeout - Общий таймаут одного запроса, включая чтение ответа.
+	HTTPTimeout = envDuration("TRANSPORT_HTTP_TIMEOUT", 10*time.Second)
+	// HTTPDialTimeout - Таймаут установки TCP соединения.
+	HTTPDialTimeout = envDuration("TRANSPORT_HTTP_DIAL_TIMEOUT", 5*time.Second)
+	// HTTPKeepAlive - Период TCP keep-alive открытых соединений.
+	HTTPKeepAlive = envDuration("TRANSPORT_HTTP_KEEPALIVE", 30*time.Second)
+	// HTTPMaxIdleConns, HTTPMaxIdleConnsPerHost - Размер пула простаивающих соединений всего и на один адрес.
+	HTTPMaxIdleConns        = envInt("TRANSPORT_HTTP_MAX_IDLE_CONNS", 100)
+	HTTPMaxIdleConnsPerHost = envInt("TRANSPORT_HTTP_MAX_IDLE_CONNS_PER_HOST", 32)
+	// HTTPMaxConnsPerHost - Максимальное количество соединений с одним адресом (0 - без ограничения).
+	HTTPMaxConnsPerHost = envInt("TRANSPORT_HTTP_MAX_CONNS_PER_HOST", 0)
+	// HTTPIdleConnTimeout - Время, после которого простаивающее соединение закрывается.
+	HTTPIdleConnTimeout = envDuration("TRANSPORT_HTTP_IDLE_TIMEOUT", 90*time.Second)
+	// HTTP2 - Использовать HTTP/2, если сервер его поддерживает (для https).
+	HTTP2 = envBool("TRANSPORT_HTTP2", true)
+	// HTTPProxy - Адрес прокси (пусто - из HTTP_PROXY/HTTPS_PROXY/NO_PROXY, "off" - без прокси).
+	HTTPProxy = envString("TRANSPORT_HTTP_PROXY", "")
+	// HTTPTLSCA - PEM файл дополнительных корневых сертификатов (пусто - только системные).
+	HTTPTLSCA = envString("TRANSPORT_HTTP_TLS_CA", "")
+	// HTTPTLSCert, HTTPTLSKey - PEM файлы клиентского сертификата и ключа для mTLS.
+	HTTPTLSCert = envString("TRANSPORT_HTTP_TLS_CERT", "")
+	HTTPTLSKey  = envString("TRANSPORT_HTTP_TLS_KEY", "")
+)
+
+// --- Пул адресов канального уровня (TRANSPORT_CHANNEL_URL) ---
+var (
+	// ChannelStrategy - Стратегия выбора адреса: round-robin, least-inflight, weighted, sticky.
+	ChannelStrategy = envString("TRANSPORT_CHANNEL_STRATEGY", "round-robin")
+	// ChannelUnhealthyAfter - Количество неудачных отправок подряд, после которого адрес считается недоступным.
+	ChannelUnhealthyAfter = envInt("TRANSPORT_CHANNEL_UNHEALTHY_AFTER", 3)
+	// ChannelRecheck - Время, через которое недоступный адрес снова получает сегменты без успешной проверки.
+	ChannelRecheck = envDuration("TRANSPORT_CHANNEL_RECHECK", 30*time.Second)
+	// ChannelHealthInterval - Период активной проверки адресов запросом HEAD (0 - только по результатам отправки).
+	ChannelHealthInterval = envDuration("TRANSPORT_CHANNEL_HEALTH_INTERVAL", 10*time.Second)
+	// ChannelHealthPath - Путь проверки на сервере адреса, отвечающий на HEAD (пусто - сам адрес).
+	// Без него проверка лишь подтверждает, что сервер отвечает: HEAD на адрес POST-обработчика обычно дает 405.
+	ChannelHealthPath = envString("TRANSPORT_CHANNEL_HEALTH_PATH", "")
+)
+
+// --- Выключатели (circuit breaker) запросов к канальному и прикладному уровням ---
+var (
+	// BreakerFailures - Количество неудач подряд (ошибка соединения, таймаут, 5xx), после которого запросы
+	// к адресу отклоняются без попытки соединения (0 - выключатели отключены).
+	BreakerFailures = envInt("TRANSPORT_BREAKER_FAILURES", 5)
+	// BreakerOpenTimeout - Время, в течение которого запросы отклоняются, до пробных запросов.
+	BreakerOpenTimeout = envDuration("TRANSPORT_BREAKER_OPEN_TIMEOUT", 30*time.Second)
+	// BreakerHalfOpenProbes - Количество одновременных пробных запросов; столько же успешных замыкают выключатель.
+	BreakerHalfOpenProbes = envInt("TRANSPORT_BREAKER_HALF_OPEN_PROBES", 1)
+)
+
+// --- Ограничение частоты запросов ("msgs=N,burst=N,bytes=N,concurrent=N" или "off") ---
+var (
+	// SendRateLimit - Лимиты /send для одного отправителя и для одного IP-адреса.
+	SendRateLimit = envRateLimit("TRANSPORT_RATELIMIT_SEND", "msgs=5,burst=10,bytes=65536,concurrent=4")
+	// TransferRateLimit - Лимиты /transfer для одного отправителя.
+	TransferRateLimit = envRateLimit("TRANSPORT_RATELIMIT_TRANSFER", "msgs=200,burst=400,bytes=262144,concurrent=64")
+)
+
+// envString возвращает значение переменной окружения или значение по умолчанию.
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// envInt возвращает целочисленное значение переменной окружения или значение по умолчанию.
+func envInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %d: %v", key, v, def, err)
+		return def
+	}
+	return n
+}
+
+// envDuration возвращает длительность из переменной окружения (формат time.ParseDuration) или значение по умолчанию.
+func envDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %s: %v", key, v, def, err)
+		return def
+	}
+	return d
+}
+
+// envBool возвращает логическое значение переменной окружения (формат strconv.ParseBool) или значение по умолчанию.
+func envBool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %t: %v", key, v, def, err)
+		return def
+	}
+	return b
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"container/heap"
+	"time"
+)
+
+// deadlineItem - Срок ожидания следующего сегмента для одного сообщения
+type deadlineItem struct {
+	key      string
+	deadline time.Time
+	index    int // Позиция в куче, поддерживается методами heap.Interface
+}
+
+// deadlineHeap - Минимальная куча сроков, реализующая heap.Interface
+type deadlineHeap []*deadlineItem
+
+func (h deadlineHeap) Len() int           { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *deadlineHeap) Push(x any) {
+	item := x.(*deadlineItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *deadlineHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// deadlineQueue - Очередь с приоритетом по сроку таймаута с доступом по ключу сообщения.
+// Обновление и удаление выполняются за O(log n), поэтому стоимость проверки таймаутов
+// не зависит от количества незавершенных сообщений. Не потокобезопасна.
+type deadlineQueue struct {
+	heap  deadlineHeap
+	items map[string]*deadlineItem
+}
+
+func newDeadlineQueue() *deadlineQueue {
+	return &deadlineQueue{items: make(map[string]*deadlineItem)}
+}
+
+// set устанавливает или переносит срок для сообщения
+func (q *deadlineQueue) set(key string, deadline time.Time) {
+	if item, ok := q.items[key]; ok {
+		item.deadline = deadline
+		heap.Fix(&q.heap, item.index)
+		return
+	}
+	item := &deadlineItem{key: key, deadline: deadline}
+	heap.Push(&q.heap, item)
+	q.items[key] = item
+}
+
+// remove удаляет срок сообщения, если он есть
+func (q *deadlineQueue) remove(key string) {
+	if item, ok := q.items[key]; ok {
+		heap.Remove(&q.heap, item.index)
+		delete(q.items, key)
+	}
+}
+
+// next возвращает ближайший срок
+func (q *deadlineQueue) next() (time.Time, bool) {
+	if len(q.heap) == 0 {
+		return time.Time{}, false
+	}
+	return q.heap[0].deadline, true
+}
+
+// popExpired извлекает ключи всех сообщений, срок которых наступил к моменту now
+func (q *deadlineQueue) popExpired(now time.Time) []string {
+	var keys []string
+	for len(q.heap) > 0 && !q.heap[0].deadline.After(now) {
+		item := heap.Pop(&q.heap).(*deadlineItem)
+		delete(q.items, item.key)
+		keys = append(keys, item.key)
+	}
+	return keys
+}
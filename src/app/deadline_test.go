@@ -0,0 +1,93 @@
+package app
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDeadlineQueue(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	tests := []struct {
+		name   string
+		apply  func(q *deadlineQueue)
+		next   int      // Ожидаемый ближайший срок в секундах; -1 - очередь пуста
+		expire int      // Момент popExpired в секундах
+		want   []string // Ключи в порядке извлечения
+	}{
+		{
+			name: "порядок кучи",
+			apply: func(q *deadlineQueue) {
+				for _, d := range []struct {
+					key     string
+					seconds int
+				}{{"c", 30}, {"a", 10}, {"d", 40}, {"b", 20}} {
+					q.set(d.key, at(d.seconds))
+				}
+			},
+			next: 10, expire: 30, want: []string{"a", "b", "c"},
+		},
+		{
+			name: "перенос срока",
+			apply: func(q *deadlineQueue) {
+				q.set("a", at(10))
+				q.set("b", at(20))
+				q.set("a", at(50)) // Позже всех
+				q.set("b", at(5))  // Раньше прежнего
+			},
+			next: 5, expire: 60, want: []string{"b", "a"},
+		},
+		{
+			name: "удаление",
+			apply: func(q *deadlineQueue) {
+				q.set("a", at(10))
+				q.set("b", at(20))
+				q.set("c", at(30))
+				q.remove("a")
+				q.remove("c")
+				q.remove("нет такого")
+			},
+			next: 20, expire: 60, want: []string{"b"},
+		},
+		{
+			name:  "пустая очередь",
+			apply: func(q *deadlineQueue) {},
+			next:  -1, expire: 60,
+		},
+		{
+			name: "срок наступает включительно",
+			apply: func(q *deadlineQueue) {
+				q.set("a", at(10))
+				q.set("b", at(11))
+			},
+			next: 10, expire: 10, want: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newDeadlineQueue()
+			tt.apply(q)
+			next, ok := q.next()
+			if tt.next < 0 {
+				if ok {
+					t.Errorf("срок у пустой очереди: %v", next)
+				}
+			} else if !ok || !next.Equal(at(tt.next)) {
+				t.Errorf("ближайший срок %v, ожидался %v", next, at(tt.next))
+			}
+			if got := q.popExpired(at(tt.expire)); !slices.Equal(got, tt.want) {
+				t.Errorf("извлечено %v, ожидалось %v", got, tt.want)
+			}
+			for _, key := range tt.want {
+				if _, ok := q.items[key]; ok {
+					t.Errorf("ключ %s остался в индексе после извлечения", key)
+				}
+			}
+			if len(q.heap) != len(q.items) {
+				t.Errorf("куча %d и индекс %d расходятся", len(q.heap), len(q.items))
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"container/list"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// dedupMetrics - Счетчики отброшенных повторов: transfer (на входе /transfer) и reassembly (в цикле сборки)
+var dedupMetrics = expvar.NewMap("dedup")
+
+// Кэши повторов: ключи завершенных сообщений и идентификаторы принятых /transfer сегментов
+var (
+	finishedMessages = newDedupCache(DedupMessages, DedupTTL)
+	acceptedSegments = newDedupCache(DedupSegments, DedupTTL)
+)
+
+// segmentIdentity возвращает идентификатор сегмента для кэша повторов
+func segmentIdentity(segment Segment) string {
+	return fmt.Sprintf("%s#%d/%d", messageKey(segment.Sender, segment.SendTime), segment.SegmentNumber, segment.TotalSegments)
+}
+
+// dedupEntry - Запись кэша повторов
+type dedupEntry struct {
+	key   string
+	added time.Time
+}
+
+// dedupCache - Ограниченный LRU кэш ключей с временем жизни. nil кэш ничего не хранит.
+type dedupCache struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	order *list.List // В начале - последние использованные записи
+	items map[string]*list.Element
+}
+
+// newDedupCache создает кэш на size ключей; при size <= 0 возвращает nil (кэш отключен)
+func newDedupCache(size int, ttl time.Duration) *dedupCache {
+	if size <= 0 {
+		return nil
+	}
+	return &dedupCache{size: size, ttl: ttl, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Add запоминает ключ, вытесняя давно не использованные записи при переполнении
+func (c *dedupCache) Add(key string, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*dedupEntry).added = now
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&dedupEntry{key: key, added: now})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Contains сообщает, встречался ли ключ в пределах времени жизни
+func (c *dedupCache) Contains(key string, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	if c.ttl > 0 && now.Sub(elem.Value.(*dedupEntry).added) > c.ttl {
+		c.removeElement(elem)
+		return false
+	}
+	c.order.MoveToFront(elem)
+	return true
+}
+
+// Len возвращает количество записей кэша (включая устаревшие, еще не удаленные)
+func (c *dedupCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *dedupCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*dedupEntry).key)
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDedupCache(t *testing.T) {
+	now := time.Now()
+	c := newDedupCache(2, time.Minute)
+
+	c.Add("a", now)
+	c.Add("b", now)
+	if !c.Contains("a", now) {
+		t.Fatal("ключ a не найден")
+	}
+	// a использован последним, поэтому вытесняется b
+	c.Add("c", now)
+	if c.Contains("b", now) || !c.Contains("a", now) || !c.Contains("c", now) {
+		t.Errorf("неверное вытеснение LRU, записей: %d", c.Len())
+	}
+	if c.Contains("a", now.Add(2*time.Minute)) {
+		t.Error("устаревший ключ a найден")
+	}
+	if c.Len() != 1 {
+		t.Errorf("записей %d, ожидалась 1", c.Len())
+	}
+
+	disabled := newDedupCache(0, time.Minute)
+	disabled.Add("a", now)
+	if disabled.Contains("a", now) || disabled.Len() != 0 {
+		t.Error("отключенный кэш хранит ключи")
+	}
+}
+
+func TestProcessSegmentDropsLateDuplicates(t *testing.T) {
+	savedAppl, savedReassembler, savedFinished, savedSinks := urlApplLevel, reassembler, finishedMessages, sinks
+	t.Cleanup(func() {
+		urlApplLevel, reassembler, finishedMessages, sinks = savedAppl, savedReassembler, savedFinished, savedSinks
+	})
+
+	appl := NewApplStub(ApplStubConfig{}, nil)
+	srv := httptest.NewServer(appl.Handler())
+	defer srv.Close()
+	urlApplLevel = srv.URL + "/receive"
+	reassembler = NewReassembler(1, time.Minute, ReassemblyLimits{})
+	finishedMessages = newDedupCache(10, time.Minute)
+	sinks = []configuredSink{{sink: &httpSink{client: http.DefaultClient}, retry: RetryPolicy{Attempts: 1}}}
+
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	for i := 1; i <= 2; i++ {
+		segment := Segment{SegmentNumber: i, TotalSegments: 2, Sender: "late", SendTime: sendTime, SegmentPayload: strconv.Itoa(i)}
+		if reason := processSegment(segment); reason != "" {
+			t.Fatalf("сегмент %d отклонен: %s", i, reason)
+		}
+	}
+	// Повтор первого сегмента после завершения не должен начинать новую сборку
+	late := Segment{SegmentNumber: 1, TotalSegments: 2, Sender: "late", SendTime: sendTime, SegmentPayload: "1"}
+	if reason := processSegment(late); reason != "" {
+		t.Fatalf("повтор отклонен: %s", reason)
+	}
+	if n := reassembler.Len(); n != 0 {
+		t.Errorf("в сборке %d сообщений, ожидалось 0", n)
+	}
+
+	waitDeliveries(5 * time.Second)
+	if received := appl.Received(); len(received) != 1 || received[0].Message.Payload != "12" {
+		t.Errorf("прикладной уровень получил %+v", received)
+	}
+}
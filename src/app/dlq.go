@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/segmentio/kafka-go"
+)
+
+// Запись топика недоставленных сегментов (dead-letter queue)
+type DeadLetterRecord struct {
+	Original  []byte    `json:"original"`  // Исходные байты записи из топика сегментов
+	Reason    string    `json:"reason"`    // Причина отклонения сегмента
+	Topic     string    `json:"topic"`     // Исходный топик
+	Partition int32     `json:"partition"` // Исходный раздел
+	Offset    int64     `json:"offset"`    // Исходный оффсет
+	Timestamp time.Time `json:"timestamp"` // Время записи в исходный топик
+	FailedAt  time.Time `json:"failed_at"` // Время отклонения сегмента
+}
+
+// pausedPartition - Раздел, приостановленный до повторной публикации записи в DLQ
+type pausedPartition struct {
+	partition confluent.TopicPartition
+	resumeAt  time.Time
+}
+
+// Записи, которые не удалось опубликовать в DLQ. Используются только горутиной сборки.
+var (
+	pendingDeadLetters = make(map[string]string) // Ключ записи deadLetterKey -> причина отклонения
+	pausedPartitions   []pausedPartition
+)
+
+// deadLetterKey формирует ключ записи по топику, разделу и оффсету
+func deadLetterKey(tp confluent.TopicPartition) string {
+	topic := ""
+	if tp.Topic != nil {
+		topic = *tp.Topic
+	}
+	return fmt.Sprintf("%s/%d/%v", topic, tp.Partition, tp.Offset)
+}
+
+// pendingDeadLetter возвращает причину отклонения записи, публикация которой в DLQ не удалась.
+// Такая запись публикуется повторно с прежней причиной без повторной обработки сегмента.
+func pendingDeadLetter(msg *confluent.Message) (string, bool) {
+	reason, ok := pendingDeadLetters[deadLetterKey(msg.TopicPartition)]
+	return reason, ok
+}
+
+// rejectSegment публикует отклоненную запись в DLQ и коммитит ее оффсет.
+// Если публикация не удалась, раздел перематывается к записи и приостанавливается на DLQRetryBackoff:
+// следующие записи раздела не обрабатываются, и их коммит не пропускает отклоненную запись.
+func rejectSegment(consumer *confluent.Consumer, msg *confluent.Message, reason string) {
+	log.Printf("Сегмент отклонен (раздел %d, оффсет %v): %s", msg.TopicPartition.Partition, msg.TopicPartition.Offset, reason)
+
+	if DLQTopic != "" {
+		record := DeadLetterRecord{
+			Original:  msg.Value,
+			Reason:    reason,
+			Partition: msg.TopicPartition.Partition,
+			Offset:    int64(msg.TopicPartition.Offset),
+			Timestamp: msg.Timestamp,
+			FailedAt:  time.Now(),
+		}
+		if msg.TopicPartition.Topic != nil {
+			record.Topic = *msg.TopicPartition.Topic
+		}
+
+		if err := publishDeadLetter(record); err != nil {
+			log.Printf("Ошибка при публикации сегмента в DLQ %s: %v", DLQTopic, err)
+			retryDeadLetter(consumer, msg, reason)
+			return
+		}
+		delete(pendingDeadLetters, deadLetterKey(msg.TopicPartition))
+		log.Printf("Сегмент опубликован в DLQ %s", DLQTopic)
+	}
+
+	if _, err := consumer.CommitMessage(msg); err != nil {
+		log.Printf("Ошибка при коммите оффсета: %v", err)
+	}
+}
+
+// retryDeadLetter перематывает раздел к записи msg и приостанавливает его на DLQRetryBackoff
+func retryDeadLetter(consumer *confluent.Consumer, msg *confluent.Message, reason string) {
+	pendingDeadLetters[deadLetterKey(msg.TopicPartition)] = reason
+	partition := confluent.TopicPartition{Topic: msg.TopicPartition.Topic, Partition: msg.TopicPartition.Partition, Offset: msg.TopicPartition.Offset}
+	if err := consumer.Pause([]confluent.TopicPartition{partition}); err != nil {
+		log.Printf("Ошибка при приостановке раздела %d: %v", partition.Partition, err)
+	}
+	// Перемотка отбрасывает уже полученные записи раздела, они будут прочитаны снова
+	if err := consumer.Seek(partition, 0); err != nil {
+		log.Printf("Ошибка при перемотке раздела %d к оффсету %v: %v", partition.Partition, partition.Offset, err)
+	}
+	pausedPartitions = append(pausedPartitions, pausedPartition{partition: partition, resumeAt: time.Now().Add(DLQRetryBackoff)})
+	log.Printf("Раздел %d приостановлен на %s, запись с оффсетом %v будет опубликована в DLQ повторно", partition.Partition, DLQRetryBackoff, partition.Offset)
+}
+
+// resumeDeadLetterPartitions возобновляет разделы, пауза которых истекла к моменту now
+func resumeDeadLetterPartitions(consumer *confluent.Consumer, now time.Time) {
+	remaining := pausedPartitions[:0]
+	for _, p := range pausedPartitions {
+		if now.Before(p.resumeAt) {
+			remaining = append(remaining, p)
+			continue
+		}
+		if err := consumer.Resume([]confluent.TopicPartition{p.partition}); err != nil {
+			log.Printf("Ошибка при возобновлении раздела %d: %v", p.partition.Partition, err)
+		}
+	}
+	pausedPartitions = remaining
+}
+
+// forgetDeadLetters сбрасывает ожидающие повтора записи при отзыве разделов: их прочитает новый владелец
+func forgetDeadLetters() {
+	clear(pendingDeadLetters)
+	pausedPartitions = nil
+}
+
+// publishDeadLetter записывает запись в топик DLQ не дольше DLQTimeout
+func publishDeadLetter(record DeadLetterRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации записи DLQ: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DLQTimeout)
+	defer cancel()
+	return writeKafkaContext(ctx, DLQTopic, nil, value)
+}
+
+// writeKafka записывает одно сообщение с ключом key (может быть nil) в указанный топик Kafka
+func writeKafka(topic string, key, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return writeKafkaContext(ctx, topic, key, value)
+}
+
+// writeKafkaContext записывает одно сообщение с ключом key в указанный топик Kafka до отмены ctx
+func writeKafkaContext(ctx context.Context, topic string, key, value []byte) error {
+	writer, err := newKafkaWriter(topic)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := writer.Close(); cErr != nil {
+			log.Printf("Ошибка при закрытии писателя Kafka: %v", cErr)
+		}
+	}()
+
+	return writer.WriteMessages(ctx, kafka.Message{Key: key, Value: value})
+}
+
+// readDeadLetters читает все записи DLQ с начала топика до текущего конца каждого раздела
+func readDeadLetters(fn func(partition int32, offset int64, record DeadLetterRecord)) error {
+	config, err := kafkaConfigMap(confluent.ConfigMap{
+		"group.id":             fmt.Sprintf("segment-dlq-cli-%d", os.Getpid()),
+		"enable.auto.commit":   false,
+		"enable.partition.eof": true,
+	})
+	if err != nil {
+		return err
+	}
+	consumer, err := confluent.NewConsumer(config)
+	if err != nil {
+		return fmt.Errorf("не удалось создать Kafka consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	topic := DLQTopic
+	metadata, err := consumer.GetMetadata(&topic, false, 5000)
+	if err != nil {
+		return fmt.Errorf("не удалось получить метаданные топика %s: %v", topic, err)
+	}
+	topicMeta, ok := metadata.Topics[topic]
+	if !ok || topicMeta.Error.Code() != confluent.ErrNoError || len(topicMeta.Partitions) == 0 {
+		return fmt.Errorf("топик %s не найден", topic)
+	}
+
+	partitions := make([]confluent.TopicPartition, 0, len(topicMeta.Partitions))
+	for _, p := range topicMeta.Partitions {
+		partitions = append(partitions, confluent.TopicPartition{Topic: &topic, Partition: p.ID, Offset: confluent.OffsetBeginning})
+	}
+	if err := consumer.Assign(partitions); err != nil {
+		return fmt.Errorf("не удалось назначить разделы: %v", err)
+	}
+
+	remaining := len(partitions)
+	for remaining > 0 {
+		switch e := consumer.Poll(5000).(type) {
+		case nil:
+			return errors.New("таймаут чтения DLQ")
+		case *confluent.Message:
+			var record DeadLetterRecord
+			if err := json.Unmarshal(e.Value, &record); err != nil {
+				log.Printf("Некорректная запись DLQ (раздел %d, оффсет %v): %v", e.TopicPartition.Partition, e.TopicPartition.Offset, err)
+				continue
+			}
+			fn(e.TopicPartition.Partition, int64(e.TopicPartition.Offset), record)
+		case confluent.PartitionEOF:
+			remaining--
+		case confluent.Error:
+			if e.IsFatal() {
+				return e
+			}
+			log.Printf("Нефатальная ошибка Kafka consumer: %v", e)
+		}
+	}
+	return nil
+}
+
+// RunDLQCommand - Команда просмотра и повторной отправки записей DLQ.
+//
+//	dlq list
+//	dlq replay [-partition N -offset N]
+func RunDLQCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("использование: dlq list | dlq replay [-partition N -offset N]")
+	}
+
+	switch args[0] {
+	case "list":
+		return readDeadLetters(func(partition int32, offset int64, record DeadLetterRecord) {
+			fmt.Printf("[%d:%d] %s %s (исходный %s/%d:%d, записан %s)\n    %s\n",
+				partition, offset, record.FailedAt.Format(time.RFC3339), record.Reason,
+				record.Topic, record.Partition, record.Offset, record.Timestamp.Format(time.RFC3339), record.Original)
+		})
+
+	case "replay":
+		fs := flag.NewFlagSet("dlq replay", flag.ContinueOnError)
+		partition := fs.Int("partition", -1, "раздел DLQ (по умолчанию все)")
+		offset := fs.Int64("offset", -1, "оффсет записи в DLQ (по умолчанию все; требует -partition)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		// Оффсеты разных разделов независимы: без раздела запись с таким оффсетом нашлась бы в каждом
+		if *offset >= 0 && *partition < 0 {
+			return errors.New("-offset задается вместе с -partition")
+		}
+
+		replayed, failed := 0, 0
+		err := readDeadLetters(func(p int32, o int64, record DeadLetterRecord) {
+			if (*partition >= 0 && int32(*partition) != p) || (*offset >= 0 && *offset != o) {
+				return
+			}
+			// Сегмент возвращается в раздел своего сообщения; некорректная запись - в любой
+			var key []byte
+			var segment Segment
+			if json.Unmarshal(record.Original, &segment) == nil {
+				key = segmentKey(segment)
+			}
+			if err := writeKafka(KafkaTopic, key, record.Original); err != nil {
+				log.Printf("Ошибка повторной отправки записи [%d:%d]: %v", p, o, err)
+				failed++
+				return
+			}
+			replayed++
+		})
+		fmt.Printf("Повторно отправлено в %s: %d, ошибок: %d\n", KafkaTopic, replayed, failed)
+		return err
+
+	default:
+		return fmt.Errorf("неизвестная команда dlq: %s", args[0])
+	}
+}
@@ -0,0 +1,24 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunDLQCommandArguments(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string // Фрагмент ожидаемой ошибки
+	}{
+		{args: nil, want: "использование"},
+		{args: []string{"purge"}, want: "неизвестная команда"},
+		{args: []string{"replay", "-offset", "42"}, want: "-partition"},
+		{args: []string{"replay", "-partition", "x"}, want: "invalid value"},
+	}
+	for _, tt := range tests {
+		err := RunDLQCommand(tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RunDLQCommand(%q): %v, ожидалась ошибка с %q", tt.args, err, tt.want)
+		}
+	}
+}
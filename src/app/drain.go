@@ -0,0 +1,187 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Режимы обработки незавершенных сообщений при остановке
+const (
+	drainModeNotify  = "notify"  // Отправить прикладному уровню уведомления об ошибке
+	drainModePersist = "persist" // Сохранить состояние в файл и восстановить при следующем запуске
+)
+
+// Учет доставок на прикладной уровень, которые еще не завершились
+var (
+	deliveryWG        sync.WaitGroup
+	pendingMutex      sync.Mutex
+	pendingDeliveries = make(map[uint64]string) // Идентификатор доставки -> описание сообщения
+	nextDeliveryID    uint64
+	deliveriesClosed  bool // Идет остановка: новые доставки не принимаются
+)
+
+// deliverToApplLevel публикует событие /events и асинхронно доставляет сообщение во все получатели
+// и подходящие подписки webhook с учетом в deliveryWG, чтобы при остановке можно было дождаться незавершенных доставок.
+func deliverToApplLevel(message OutputMessage) {
+	publishOutputEvent(message)
+
+	for _, s := range append(sinks, webhookSinks(message)...) {
+		description := fmt.Sprintf("%s -> %s", messageKey(message.Sender, message.SendTime), s.sink.Name())
+		trackDelivery(description, func() { deliverToSink(s, message) })
+	}
+}
+
+// trackDelivery выполняет доставку в отдельной горутине с учетом в deliveryWG и pendingDeliveries.
+// После closeDeliveries доставка не выполняется: deliveryWG.Add не должен выполняться одновременно с Wait.
+func trackDelivery(description string, deliver func()) {
+	pendingMutex.Lock()
+	if deliveriesClosed {
+		pendingMutex.Unlock()
+		log.Printf("Доставка %s отклонена: транспортный уровень останавливается", description)
+		return
+	}
+	nextDeliveryID++
+	id := nextDeliveryID
+	pendingDeliveries[id] = description
+	deliveryWG.Add(1)
+	pendingMutex.Unlock()
+
+	go func() {
+		defer deliveryWG.Done()
+		defer func() {
+			pendingMutex.Lock()
+			delete(pendingDeliveries, id)
+			pendingMutex.Unlock()
+		}()
+		deliver()
+	}()
+}
+
+// closeDeliveries запрещает новые доставки перед ожиданием незавершенных при остановке
+func closeDeliveries() {
+	pendingMutex.Lock()
+	deliveriesClosed = true
+	pendingMutex.Unlock()
+}
+
+// waitDeliveries ожидает завершения доставок не дольше timeout и возвращает ключи незавершенных.
+// При остановке вызывается после closeDeliveries.
+func waitDeliveries(timeout time.Duration) []string {
+	done := make(chan struct{})
+	go func() {
+		deliveryWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+	abandoned := make([]string, 0, len(pendingDeliveries))
+	for _, key := range pendingDeliveries {
+		abandoned = append(abandoned, key)
+	}
+	return abandoned
+}
+
+// drainInFlight завершает обработку незавершенных сообщений при остановке горутины сборки.
+// Полностью собранные сообщения доставляются, незавершенные сохраняются в файл или
+// завершаются уведомлением об ошибке в зависимости от DrainMode.
+func drainInFlight() {
+	log.Printf("Штатное завершение сборки: режим '%s', таймаут доставки %s", DrainMode, DrainTimeout)
+
+	incomplete := make(map[string]*MessageReassemblyState)
+	flushed := 0
+	for key, state := range reassembler.Drain() {
+		if len(state.Segments) == state.TotalSegmentsExpected {
+			output := formatOutputMessage(state, true)
+			recordHistory(key, state, historyCompleted, output)
+			deliverToApplLevel(output)
+			flushed++
+		} else {
+			incomplete[key] = state
+		}
+	}
+
+	var lost []string
+	notified := 0
+	if DrainMode == drainModePersist && len(incomplete) > 0 {
+		if err := persistInFlight(incomplete); err != nil {
+			log.Printf("Ошибка при сохранении незавершенных сообщений в %s: %v", DrainStateFile, err)
+			for key := range incomplete {
+				lost = append(lost, key)
+			}
+		} else {
+			log.Printf("Сохранено незавершенных сообщений: %d (%s)", len(incomplete), DrainStateFile)
+		}
+	} else {
+		for key, state := range incomplete {
+			output := formatOutputMessage(state, false)
+			output.ErrorMsg = fmt.Sprintf("Транспортный уровень остановлен до завершения сборки. Ожидалось %d сегментов, получено %d.", state.TotalSegmentsExpected, len(state.Segments))
+			recordHistory(key, state, historyTimeout, output)
+			deliverToApplLevel(output)
+			notified++
+		}
+	}
+
+	closeDeliveries()
+	abandoned := waitDeliveries(DrainTimeout)
+
+	log.Printf("Итоги завершения сборки: доставлено собранных %d, уведомлений об ошибке %d, сохранено %d, потеряно %d, незавершенных доставок %d",
+		flushed, notified, len(incomplete)-notified-len(lost), len(lost), len(abandoned))
+	for _, key := range lost {
+		log.Printf("Потеряно незавершенное сообщение '%s'", key)
+	}
+	for _, key := range abandoned {
+		log.Printf("Не дождались доставки сообщения '%s' на прикладной уровень", key)
+	}
+}
+
+// persistInFlight сохраняет состояние незавершенных сообщений в DrainStateFile
+func persistInFlight(states map[string]*MessageReassemblyState) error {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	tmp := DrainStateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, DrainStateFile)
+}
+
+// restoreInFlight загружает сохраненные при прошлой остановке незавершенные сообщения.
+func restoreInFlight() map[string]*MessageReassemblyState {
+	states := make(map[string]*MessageReassemblyState)
+	if DrainMode != drainModePersist {
+		return states
+	}
+
+	data, err := os.ReadFile(DrainStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return states
+	}
+	if err != nil {
+		log.Printf("Ошибка чтения сохраненного состояния %s: %v", DrainStateFile, err)
+		return states
+	}
+	if err := json.Unmarshal(data, &states); err != nil {
+		log.Printf("Ошибка разбора сохраненного состояния %s: %v", DrainStateFile, err)
+		return make(map[string]*MessageReassemblyState)
+	}
+	if err := os.Remove(DrainStateFile); err != nil {
+		log.Printf("Не удалось удалить файл состояния %s: %v", DrainStateFile, err)
+	}
+
+	log.Printf("Восстановлено незавершенных сообщений: %d", len(states))
+	return states
+}
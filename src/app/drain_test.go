@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink запоминает доставленные сообщения
+type recordingSink struct {
+	mu       sync.Mutex
+	messages []OutputMessage
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Deliver(ctx context.Context, message OutputMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+func (s *recordingSink) delivered() []OutputMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]OutputMessage(nil), s.messages...)
+}
+
+// withDrain задает режим остановки, движок сборки и получателя на время теста
+func withDrain(t *testing.T, mode string) *recordingSink {
+	t.Helper()
+	savedMode, savedFile, savedReassembler, savedSinks := DrainMode, DrainStateFile, reassembler, sinks
+	t.Cleanup(func() {
+		DrainMode, DrainStateFile, reassembler, sinks = savedMode, savedFile, savedReassembler, savedSinks
+		pendingMutex.Lock()
+		deliveriesClosed = false
+		pendingMutex.Unlock()
+	})
+	sink := &recordingSink{}
+	DrainMode, DrainStateFile = mode, filepath.Join(t.TempDir(), "inflight_state.json")
+	reassembler = NewReassembler(2, time.Minute, ReassemblyLimits{})
+	sinks = []configuredSink{{sink: sink, retry: RetryPolicy{Attempts: 1}}}
+	return sink
+}
+
+// fillReassembler добавляет в движок одно собранное целиком и одно незавершенное сообщение
+func fillReassembler(t *testing.T) {
+	t.Helper()
+	complete := testSegment("complete", 1, 1, "готово")
+	reassembler.Restore(map[string]*MessageReassemblyState{
+		messageKey(complete.Sender, complete.SendTime): {
+			Segments:              map[int]Segment{1: complete},
+			TotalSegmentsExpected: 1,
+			Sender:                complete.Sender,
+			SendTime:              complete.SendTime,
+		},
+	}, time.Now())
+	if status, _ := reassembler.Add(testSegment("partial", 1, 2, "при"), time.Now()); status != SegmentAdded {
+		t.Fatalf("незавершенное сообщение не добавлено: %d", status)
+	}
+}
+
+func TestDrainInFlightPersistAndRestore(t *testing.T) {
+	sink := withDrain(t, drainModePersist)
+	fillReassembler(t)
+
+	drainInFlight()
+	if got := sink.delivered(); len(got) != 1 || got[0].Sender != "complete" || got[0].Error {
+		t.Fatalf("доставлено при остановке: %+v", got)
+	}
+	if _, err := os.Stat(DrainStateFile); err != nil {
+		t.Fatalf("состояние не сохранено: %v", err)
+	}
+
+	// После начала остановки новые доставки не принимаются
+	trackDelivery("после остановки", func() { t.Error("доставка выполнена после остановки") })
+	if abandoned := waitDeliveries(time.Second); len(abandoned) != 0 {
+		t.Errorf("незавершенные доставки: %v", abandoned)
+	}
+
+	states := restoreInFlight()
+	if len(states) != 1 {
+		t.Fatalf("восстановлено %d сообщений", len(states))
+	}
+	if _, err := os.Stat(DrainStateFile); !os.IsNotExist(err) {
+		t.Errorf("файл состояния не удален после восстановления: %v", err)
+	}
+	restored := NewReassembler(2, time.Minute, ReassemblyLimits{})
+	restored.Restore(states, time.Now())
+	status, results := restored.Add(testSegment("partial", 2, 2, "вет"), time.Now())
+	if status != SegmentCompleted || results[0].Output.Payload != "привет" {
+		t.Errorf("сборка после восстановления: %d %+v", status, results)
+	}
+	if again := restoreInFlight(); len(again) != 0 {
+		t.Errorf("состояние восстановлено повторно: %d", len(again))
+	}
+}
+
+func TestDrainInFlightNotify(t *testing.T) {
+	sink := withDrain(t, drainModeNotify)
+	fillReassembler(t)
+
+	drainInFlight()
+	got := make(map[string]OutputMessage)
+	for _, message := range sink.delivered() {
+		got[message.Sender] = message
+	}
+	if len(got) != 2 || got["complete"].Error || !got["partial"].Error || got["partial"].ErrorMsg == "" {
+		t.Errorf("доставлено при остановке: %+v", got)
+	}
+	if _, err := os.Stat(DrainStateFile); !os.IsNotExist(err) {
+		t.Errorf("в режиме notify создан файл состояния: %v", err)
+	}
+	if reassembler.Len() != 0 {
+		t.Errorf("после остановки в движке %d сообщений", reassembler.Len())
+	}
+}
@@ -0,0 +1,353 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// e2eTimeout - Таймаут сборки в тестах, уменьшенный для скорости
+const e2eTimeout = 300 * time.Millisecond
+
+// e2eHarness связывает HandleSend, симулированный канальный уровень, HandleTransfer,
+// шину сегментов в памяти, движок сборки и заглушку прикладного уровня.
+type e2eHarness struct {
+	transport *httptest.Server
+	appl      *ApplStub
+
+	mu       sync.Mutex
+	rejected []string // Причины отклонения сегментов движком сборки
+}
+
+// newE2EHarness запускает окружение; newChannel создает обработчик канального уровня,
+// пересылающий сегменты на переданный адрес /transfer.
+func newE2EHarness(t *testing.T, newChannel func(target string) http.Handler) *e2eHarness {
+	t.Helper()
+
+	// Сохранение и восстановление глобального состояния пакета
+	savedChannel, savedAppl := urlChannelLevel, urlApplLevel
+	savedProducer, savedReassembler := segmentProducer, reassembler
+	savedSend, savedTransfer := sendLimiter, transferLimiter
+	savedFinished, savedAccepted := finishedMessages, acceptedSegments
+	t.Cleanup(func() {
+		urlChannelLevel, urlApplLevel = savedChannel, savedAppl
+		segmentProducer, reassembler = savedProducer, savedReassembler
+		sendLimiter, transferLimiter = savedSend, savedTransfer
+		finishedMessages, acceptedSegments = savedFinished, savedAccepted
+	})
+
+	h := &e2eHarness{appl: NewApplStub(ApplStubConfig{}, nil)}
+	reassembler = NewReassembler(4, e2eTimeout, ReassemblyLimits{})
+	sendLimiter, transferLimiter = nil, nil
+	finishedMessages, acceptedSegments = newDedupCache(100, time.Minute), newDedupCache(1000, time.Minute)
+
+	// Шина сегментов в памяти вместо Kafka
+	bus := make(chan Segment, 64)
+	segmentProducer = func(segment Segment, errChan chan<- error) {
+		defer close(errChan)
+		bus <- segment
+		errChan <- nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case segment := <-bus:
+				if reason := processSegment(segment); reason != "" {
+					h.mu.Lock()
+					h.rejected = append(h.rejected, reason)
+					h.mu.Unlock()
+				}
+			case <-ticker.C:
+				expireMessages()
+			}
+		}
+	}()
+
+	router := mux.NewRouter().UseEncodedPath()
+	router.HandleFunc("/send", HandleSend).Methods(http.MethodPost)
+	router.HandleFunc("/transfer", HandleTransfer).Methods(http.MethodPost)
+	router.HandleFunc("/messages/{id}/status", HandleMessageStatus).Methods(http.MethodGet)
+	h.transport = httptest.NewServer(router)
+
+	channel := httptest.NewServer(newChannel(h.transport.URL + "/transfer"))
+	applSrv := httptest.NewServer(h.appl.Handler())
+	urlChannelLevel = channel.URL + "/code"
+	urlApplLevel = applSrv.URL + "/receive"
+
+	t.Cleanup(func() {
+		channel.Close()
+		h.transport.Close()
+		cancel()
+		<-done
+		waitDeliveries(5 * time.Second)
+		applSrv.Close()
+	})
+	return h
+}
+
+// send отправляет сообщение на /send транспортного уровня
+func (h *e2eHarness) send(t *testing.T, sender, payload string, sendTime time.Time) {
+	t.Helper()
+	body, _ := json.Marshal(SendRequest{Sender: sender, SendTime: sendTime, Payload: payload})
+	resp, err := http.Post(h.transport.URL+"/send", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /send: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /send: статус %s", resp.Status)
+	}
+}
+
+// received ожидает, пока заглушка прикладного уровня получит count сообщений отправителя
+func (h *e2eHarness) received(t *testing.T, sender string, count int, timeout time.Duration) []OutputMessage {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		var messages []OutputMessage
+		for _, m := range h.appl.Received() {
+			if m.Message.Sender == sender {
+				messages = append(messages, m.Message)
+			}
+		}
+		if len(messages) >= count || time.Now().After(deadline) {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// status ожидает окончательного статуса доставки сообщения по квитанции
+func (h *e2eHarness) status(t *testing.T, sender string, sendTime time.Time, timeout time.Duration) MessageStatus {
+	t.Helper()
+	target := h.transport.URL + "/messages/" + url.PathEscape(messageKey(sender, sendTime)) + "/status"
+	deadline := time.Now().Add(timeout)
+	for {
+		var status MessageStatus
+		resp, err := http.Get(target)
+		if err != nil {
+			t.Fatalf("GET статуса: %v", err)
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if status.final() || time.Now().After(deadline) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (h *e2eHarness) rejections() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.rejected...)
+}
+
+// scriptedChannel - Канальный уровень с детерминированным поведением: накапливает все сегменты
+// сообщения, применяет к ним transform и пересылает результат последовательно.
+type scriptedChannel struct {
+	target    string
+	transform func([]Segment) []Segment
+
+	mu      sync.Mutex
+	pending map[string][]Segment
+}
+
+func newScriptedChannel(transform func([]Segment) []Segment) func(string) http.Handler {
+	return func(target string) http.Handler {
+		return &scriptedChannel{target: target, transform: transform, pending: make(map[string][]Segment)}
+	}
+}
+
+func (c *scriptedChannel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var segment Segment
+	if err := json.NewDecoder(r.Body).Decode(&segment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := messageKey(segment.Sender, segment.SendTime)
+	c.mu.Lock()
+	c.pending[key] = append(c.pending[key], segment)
+	segments := c.pending[key]
+	complete := len(segments) == segment.TotalSegments
+	if complete {
+		delete(c.pending, key)
+	}
+	c.mu.Unlock()
+
+	if complete {
+		slices.SortFunc(segments, func(a, b Segment) int { return a.SegmentNumber - b.SegmentNumber })
+		// Искажается только передача сообщения, квитанции доставляются как есть
+		if c.transform != nil && segment.Type == segmentData {
+			segments = c.transform(segments)
+		}
+		go func() {
+			for _, s := range segments {
+				body, _ := json.Marshal(s)
+				resp, err := http.Post(c.target, "application/json", bytes.NewReader(body))
+				if err == nil {
+					resp.Body.Close()
+				}
+			}
+		}()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestEndToEnd(t *testing.T) {
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	multi := strings.Repeat("abcdefghij", 50)
+	unicode := strings.Repeat("Привет, 世界! 🙂 ", 30)
+
+	tests := []struct {
+		name         string
+		payload      string
+		channel      func(target string) http.Handler
+		wantError    string // Подстрока ErrorMsg; пусто - ожидается успешная сборка
+		wantRejected int
+	}{
+		{
+			name:    "один сегмент",
+			payload: "Hello, world!",
+			channel: newScriptedChannel(nil),
+		},
+		{
+			name:    "несколько сегментов",
+			payload: multi,
+			channel: newScriptedChannel(nil),
+		},
+		{
+			name:    "юникод на границах сегментов",
+			payload: unicode,
+			channel: newScriptedChannel(nil),
+		},
+		{
+			name:    "потеря сегмента",
+			payload: multi,
+			channel: newScriptedChannel(func(s []Segment) []Segment {
+				return append(s[:1:1], s[2:]...)
+			}),
+			wantError: fmt.Sprintf("получено %d", len(splitSegment(multi, SegmentSize))-1),
+		},
+		{
+			name:    "дубликаты до завершения",
+			payload: multi,
+			channel: newScriptedChannel(func(s []Segment) []Segment {
+				last := len(s) - 1
+				return append(append(append([]Segment{}, s[:last]...), s[:last]...), s[last])
+			}),
+		},
+		{
+			name:    "поздние повторы после завершения",
+			payload: multi,
+			channel: newScriptedChannel(func(s []Segment) []Segment {
+				return append(append([]Segment{}, s...), s...)
+			}),
+		},
+		{
+			name:    "обратный порядок",
+			payload: unicode,
+			channel: newScriptedChannel(func(s []Segment) []Segment {
+				slices.Reverse(s)
+				return s
+			}),
+		},
+		{
+			name:    "таймаут после первого сегмента",
+			payload: multi,
+			channel: newScriptedChannel(func(s []Segment) []Segment {
+				return s[:1]
+			}),
+			wantError: "получено 1",
+		},
+		{
+			name:    "несовпадающие метаданные",
+			payload: multi,
+			channel: newScriptedChannel(func(s []Segment) []Segment {
+				bad := s[1]
+				bad.TotalSegments++
+				return append([]Segment{s[0], bad}, s[1:]...)
+			}),
+			wantRejected: 1,
+		},
+		{
+			name:    "задержки и переупорядочивание channel-sim",
+			payload: unicode,
+			channel: func(target string) http.Handler {
+				return NewChannelSim(ChannelSimConfig{
+					TargetURL:    target,
+					ReorderProb:  0.5,
+					ReorderDelay: 50 * time.Millisecond,
+					DelayDist:    "uniform",
+					DelayMean:    20 * time.Millisecond,
+					DelayJitter:  10 * time.Millisecond,
+					Seed:         7,
+				}).Handler()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newE2EHarness(t, tt.channel)
+			sender := "user_" + strings.ReplaceAll(tt.name, " ", "_")
+			h.send(t, sender, tt.payload, sendTime)
+
+			messages := h.received(t, sender, 1, 5*time.Second)
+			if len(messages) == 0 {
+				t.Fatal("прикладной уровень не получил сообщение")
+			}
+			// Лишние сообщения (например, ложные таймауты) должны успеть прийти
+			time.Sleep(e2eTimeout + 100*time.Millisecond)
+			messages = h.received(t, sender, 1, 0)
+			if len(messages) != 1 {
+				t.Fatalf("получено %d сообщений, ожидалось 1: %+v", len(messages), messages)
+			}
+
+			got := messages[0]
+			if !got.SendTime.Equal(sendTime) {
+				t.Errorf("send_time = %v, ожидалось %v", got.SendTime, sendTime)
+			}
+			if tt.wantError == "" {
+				if got.Error || got.Payload != tt.payload {
+					t.Errorf("сообщение собрано неверно: error=%v (%s), payload=%q", got.Error, got.ErrorMsg, got.Payload)
+				}
+			} else if !got.Error || !strings.Contains(got.ErrorMsg, tt.wantError) {
+				t.Errorf("ожидалась ошибка с %q, получено error=%v (%s)", tt.wantError, got.Error, got.ErrorMsg)
+			}
+
+			// Квитанция возвращается отправителю через канальный уровень
+			wantStatus := deliveryDelivered
+			if tt.wantError != "" {
+				wantStatus = deliveryFailed
+			}
+			if status := h.status(t, sender, sendTime, 5*time.Second); status.Status != wantStatus {
+				t.Errorf("статус доставки %q, ожидался %q: %+v", status.Status, wantStatus, status)
+			}
+
+			if rejected := h.rejections(); len(rejected) != tt.wantRejected {
+				t.Errorf("отклонено сегментов: %d, ожидалось %d: %v", len(rejected), tt.wantRejected, rejected)
+			}
+		})
+	}
+}
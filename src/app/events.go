@@ -0,0 +1,231 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Типы событий потока /events
+const (
+	eventMessage = "message" // Сообщение собрано
+	eventError   = "error"   // Сообщение не собрано (таймаут, вытеснение, принудительное завершение)
+	eventSegment = "segment" // Получен сегмент (публикуется при TRANSPORT_EVENTS_SEGMENTS=true)
+)
+
+// sseKeepAlive - Период отправки комментария-пинга, чтобы прокси не закрывали простаивающий поток
+const sseKeepAlive = 15 * time.Second
+
+// Event - Событие доставки, хранимое в журнале событий
+type Event struct {
+	ID     uint64
+	Type   string
+	Sender string // Для фильтра ?sender=
+	Data   []byte // JSON полезной нагрузки события
+}
+
+// SegmentEvent - Полезная нагрузка события о полученном сегменте
+type SegmentEvent struct {
+	Key           string    `json:"key"`
+	Sender        string    `json:"sender"`
+	SendTime      time.Time `json:"send_time"`
+	SegmentNumber int       `json:"segment_number"`
+	TotalSegments int       `json:"total_segments"`
+	Duplicate     bool      `json:"duplicate,omitempty"`
+}
+
+// eventLog - Ограниченный журнал последних событий для возобновления потока по Last-Event-ID
+type eventLog struct {
+	mu      sync.Mutex
+	events  []Event // Кольцевой буфер
+	next    int     // Позиция следующей записи
+	count   int
+	lastID  uint64
+	changed chan struct{} // Закрывается и заменяется при каждой публикации
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]Event, max(size, 1)), changed: make(chan struct{})}
+}
+
+// Журнал событий доставки
+var deliveryEvents = newEventLog(EventLogSize)
+
+// streamCloser - Сигнал остановки сервера для бесконечных потоков
+type streamCloser struct {
+	done chan struct{}
+	once sync.Once
+}
+
+// Потоки /events завершаются при остановке сервера, не задерживая srv.Shutdown
+var eventStreams = &streamCloser{done: make(chan struct{})}
+
+// CloseEventStreams завершает открытые потоки /events; регистрируется через srv.RegisterOnShutdown
+func CloseEventStreams() {
+	streams := eventStreams
+	streams.once.Do(func() { close(streams.done) })
+}
+
+// publish добавляет событие и будит ожидающие потоки
+func (l *eventLog) publish(eventType, sender string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Ошибка при маршалинге события %s: %v", eventType, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	l.events[l.next] = Event{ID: l.lastID, Type: eventType, Sender: sender, Data: data}
+	l.next = (l.next + 1) % len(l.events)
+	if l.count < len(l.events) {
+		l.count++
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// last возвращает ID последнего опубликованного события
+func (l *eventLog) last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID
+}
+
+// since возвращает события с ID больше after и канал, закрываемый при появлении новых.
+// missed - признак того, что часть событий после after уже вытеснена из журнала.
+func (l *eventLog) since(after uint64) (events []Event, changed <-chan struct{}, missed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := l.count; i >= 1; i-- {
+		event := l.events[(l.next-i+len(l.events))%len(l.events)]
+		if event.ID > after {
+			events = append(events, event)
+		}
+	}
+	missed = len(events) > 0 && events[0].ID > after+1
+	return events, l.changed, missed
+}
+
+// publishOutputEvent публикует событие о собранном или несобранном сообщении
+func publishOutputEvent(message OutputMessage) {
+	eventType := eventMessage
+	if message.Error {
+		eventType = eventError
+	}
+	deliveryEvents.publish(eventType, message.Sender, message)
+}
+
+// publishSegmentEvent публикует событие о полученном сегменте, если это включено
+func publishSegmentEvent(key string, segment Segment, duplicate bool) {
+	if !EventsSegments {
+		return
+	}
+	deliveryEvents.publish(eventSegment, segment.Sender, SegmentEvent{
+		Key:           key,
+		Sender:        segment.Sender,
+		SendTime:      segment.SendTime,
+		SegmentNumber: segment.SegmentNumber,
+		TotalSegments: segment.TotalSegments,
+		Duplicate:     duplicate,
+	})
+}
+
+// HandleEvents - Поток Server-Sent Events о доставке сообщений.
+// Параметры: types (через запятую, по умолчанию message,error), sender; возобновление по
+// заголовку Last-Event-ID или параметру last_event_id.
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if !authorizeStream(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	types := map[string]bool{eventMessage: true, eventError: true}
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(value, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+	sender := r.URL.Query().Get("sender")
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	restarted := false
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			http.Error(w, "Некорректный Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		// ID больше последнего: сервер перезапущен и нумерация началась заново
+		if after > deliveryEvents.last() {
+			restarted = true
+		}
+	} else {
+		// Новый подписчик получает только события после подключения
+		after = deliveryEvents.last()
+	}
+
+	// Поток длится дольше WriteTimeout сервера
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Не удалось снять таймаут записи для /events: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if restarted {
+		fmt.Fprintf(w, ": пропущены события после %d (сервер перезапущен)\n\n", after)
+		after = 0
+	}
+
+	shutdown := eventStreams.done
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		events, changed, missed := deliveryEvents.since(after)
+		if missed {
+			// Клиент отстал больше, чем хранит журнал
+			fmt.Fprintf(w, ": пропущены события после %d\n\n", after)
+		}
+		for _, event := range events {
+			after = event.ID
+			if !types[event.Type] || (sender != "" && event.Sender != sender) {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shutdown:
+			return
+		case <-changed:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
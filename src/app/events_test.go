@@ -0,0 +1,118 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSSE читает из потока count событий и возвращает их строки id/event
+func readSSE(t *testing.T, scanner *bufio.Scanner, count int) []string {
+	t.Helper()
+	var events []string
+	var current []string
+	for len(events) < count && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(current) > 0 {
+				events = append(events, strings.Join(current, " "))
+				current = nil
+			}
+		case strings.HasPrefix(line, "id: "), strings.HasPrefix(line, "event: "):
+			current = append(current, line)
+		}
+	}
+	if len(events) < count {
+		t.Fatalf("получено %d событий из %d: %v", len(events), count, scanner.Err())
+	}
+	return events
+}
+
+func TestEventsResume(t *testing.T) {
+	saved := deliveryEvents
+	t.Cleanup(func() { deliveryEvents = saved })
+	deliveryEvents = newEventLog(3)
+
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	publishOutputEvent(OutputMessage{Sender: "a", SendTime: sendTime, Payload: "1"})
+	publishOutputEvent(OutputMessage{Sender: "b", SendTime: sendTime, Error: true, ErrorMsg: "таймаут"})
+	publishOutputEvent(OutputMessage{Sender: "a", SendTime: sendTime, Payload: "3"})
+
+	withStreamsToken(t, "s3cret")
+	srv := httptest.NewServer(http.HandlerFunc(HandleEvents))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?sender=a", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	// Событие 2 отфильтровано по отправителю, событие 3 - из журнала
+	if got := readSSE(t, scanner, 1); got[0] != "id: 3 event: message" {
+		t.Errorf("возобновление: %v", got)
+	}
+	// Новое событие приходит в открытый поток
+	publishOutputEvent(OutputMessage{Sender: "a", SendTime: sendTime, Error: true})
+	if got := readSSE(t, scanner, 1); got[0] != "id: 4 event: error" {
+		t.Errorf("новое событие: %v", got)
+	}
+
+	// Журнал хранит 3 события; при отставании клиент получает оставшиеся
+	events, _, missed := deliveryEvents.since(0)
+	if !missed || len(events) != 3 || events[0].ID != 2 {
+		t.Errorf("since(0): missed=%v, событий %d", missed, len(events))
+	}
+}
+
+func TestEventsAfterRestartAndShutdown(t *testing.T) {
+	saved, savedStreams := deliveryEvents, eventStreams
+	t.Cleanup(func() { deliveryEvents, eventStreams = saved, savedStreams })
+	deliveryEvents = newEventLog(10)
+	eventStreams = &streamCloser{done: make(chan struct{})}
+
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	publishOutputEvent(OutputMessage{Sender: "a", SendTime: sendTime, Payload: "1"})
+	publishOutputEvent(OutputMessage{Sender: "a", SendTime: sendTime, Payload: "2"})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(HandleEvents))
+	srv.Config.RegisterOnShutdown(CloseEventStreams)
+	srv.Start()
+	defer srv.Close()
+
+	// Last-Event-ID от прежнего процесса больше последнего ID: поток начинается с начала журнала
+	withStreamsToken(t, "s3cret")
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?token=s3cret", nil)
+	req.Header.Set("Last-Event-ID", "500")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() || !strings.Contains(scanner.Text(), "пропущены события после 500") {
+		t.Fatalf("нет комментария о пропуске: %q", scanner.Text())
+	}
+	if events := readSSE(t, scanner, 2); events[0] != "id: 1 event: message" || events[1] != "id: 2 event: message" {
+		t.Errorf("события после перезапуска: %v", events)
+	}
+
+	// Открытый поток не задерживает остановку сервера
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown с открытым потоком /events: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Результаты проверок готовности по компонентам (пустая строка - компонент готов)
+var (
+	readinessMu     sync.Mutex
+	readinessChecks = make(map[string]string)
+)
+
+// setReadiness сохраняет результат проверки готовности компонента
+func setReadiness(component string, err error) {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+	if err != nil {
+		readinessChecks[component] = err.Error()
+	} else {
+		readinessChecks[component] = ""
+	}
+}
+
+// readinessReport возвращает признак готовности и ошибки неготовых компонентов
+func readinessReport() (bool, map[string]string) {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+	failures := make(map[string]string)
+	for component, msg := range readinessChecks {
+		if msg != "" {
+			failures[component] = msg
+		}
+	}
+	return len(failures) == 0, failures
+}
+
+// RegisterHealthRoutes регистрирует /healthz (процесс жив) и /readyz (готовность к работе)
+func RegisterHealthRoutes(r *mux.Router) {
+	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, failures := readinessReport()
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		components := make([]string, 0, len(failures))
+		for component := range failures {
+			components = append(components, component)
+		}
+		sort.Strings(components)
+		writeJSON(w, status, map[string]any{"ready": ready, "not_ready": components, "errors": failures})
+	}).Methods(http.MethodGet)
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Общий HTTP клиент исходящих запросов: сегменты канальному уровню, сообщения прикладному уровню,
+// квитанции и webhook. Соединения переиспользуются между запросами.
+// При ошибке настройки клиент работает без TLS параметров, а ошибка возвращается CheckHTTPClient.
+var outboundHTTP, outboundHTTPErr = newOutboundClient()
+
+// CheckHTTPClient возвращает ошибку настройки исходящего HTTP клиента (прокси, сертификаты)
+func CheckHTTPClient() error {
+	return outboundHTTPErr
+}
+
+// newOutboundClient создает HTTP клиент по параметрам TRANSPORT_HTTP_*
+func newOutboundClient() (*http.Client, error) {
+	dialer := &net.Dialer{Timeout: HTTPDialTimeout, KeepAlive: HTTPKeepAlive}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     HTTP2,
+		MaxIdleConns:          HTTPMaxIdleConns,
+		MaxIdleConnsPerHost:   HTTPMaxIdleConnsPerHost,
+		MaxConnsPerHost:       HTTPMaxConnsPerHost,
+		IdleConnTimeout:       HTTPIdleConnTimeout,
+		TLSHandshakeTimeout:   HTTPDialTimeout,
+		ExpectContinueTimeout: HTTPDialTimeout,
+	}
+	if !HTTP2 {
+		// Непустая карта отключает переход на HTTP/2 через ALPN
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	client := &http.Client{Transport: transport, Timeout: HTTPTimeout}
+
+	proxy, err := httpProxy()
+	if err != nil {
+		return client, err
+	}
+	transport.Proxy = proxy
+
+	transport.TLSClientConfig, err = httpTLSConfig()
+	return client, err
+}
+
+// httpProxy выбирает прокси согласно HTTPProxy; nil - без прокси
+func httpProxy() (func(*http.Request) (*url.URL, error), error) {
+	switch strings.ToLower(HTTPProxy) {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "off", "none":
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(HTTPProxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("некорректный адрес прокси %q", HTTPProxy)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// httpTLSConfig собирает настройки TLS исходящих запросов; nil - настройки по умолчанию
+func httpTLSConfig() (*tls.Config, error) {
+	if HTTPTLSCA == "" && HTTPTLSCert == "" && HTTPTLSKey == "" {
+		return nil, nil
+	}
+	if (HTTPTLSCert == "") != (HTTPTLSKey == "") {
+		return nil, errors.New("клиентский сертификат и ключ задаются вместе (TRANSPORT_HTTP_TLS_CERT, TRANSPORT_HTTP_TLS_KEY)")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if HTTPTLSCA != "" {
+		pem, err := os.ReadFile(HTTPTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать сертификаты CA: %v", err)
+		}
+		// Дополнительные сертификаты добавляются к системным
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("в файле %s нет PEM сертификатов", HTTPTLSCA)
+		}
+	}
+	if HTTPTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(HTTPTLSCert, HTTPTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось загрузить клиентский сертификат: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
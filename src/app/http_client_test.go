@@ -0,0 +1,122 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// withHTTPClientSettings задает параметры исходящего HTTP клиента на время теста
+func withHTTPClientSettings(t *testing.T, proxy, ca, cert, key string) {
+	t.Helper()
+	saved := []string{HTTPProxy, HTTPTLSCA, HTTPTLSCert, HTTPTLSKey}
+	t.Cleanup(func() {
+		HTTPProxy, HTTPTLSCA, HTTPTLSCert, HTTPTLSKey = saved[0], saved[1], saved[2], saved[3]
+	})
+	HTTPProxy, HTTPTLSCA, HTTPTLSCert, HTTPTLSKey = proxy, ca, cert, key
+}
+
+func TestOutboundClientSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxy     string
+		cert, key string
+		noProxy   bool
+		wantErr   bool
+	}{
+		{name: "по умолчанию"},
+		{name: "без прокси", proxy: "off", noProxy: true},
+		{name: "явный прокси", proxy: "http://proxy.local:3128"},
+		{name: "некорректный прокси", proxy: "proxy.local", wantErr: true},
+		{name: "сертификат без ключа", cert: "cert.pem", wantErr: true},
+		{name: "отсутствующий сертификат", cert: "missing.pem", key: "missing.pem", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHTTPClientSettings(t, tt.proxy, "", tt.cert, tt.key)
+			client, err := newOutboundClient()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка = %v, ожидалась: %v", err, tt.wantErr)
+			}
+			if client.Timeout != HTTPTimeout {
+				t.Errorf("таймаут = %s, ожидался %s", client.Timeout, HTTPTimeout)
+			}
+			if tt.wantErr {
+				return
+			}
+			if transport := client.Transport.(*http.Transport); (transport.Proxy == nil) != tt.noProxy {
+				t.Errorf("прокси задан: %v, ожидалось: %v", transport.Proxy != nil, !tt.noProxy)
+			}
+		})
+	}
+}
+
+func TestOutboundClientMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeTestCertificate(t, dir)
+	clientPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientPEM)
+
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// Сертификат тестового сервера передается как дополнительный корневой
+	caFile := filepath.Join(dir, "server-ca.pem")
+	serverPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, serverPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	withHTTPClientSettings(t, "off", caFile, "", "")
+	client, err := newOutboundClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("запрос без клиентского сертификата должен быть отклонен")
+	}
+
+	withHTTPClientSettings(t, "off", caFile, certFile, keyFile)
+	if client, err = newOutboundClient(); err != nil {
+		t.Fatal(err)
+	}
+	connections.Store(0)
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("запрос %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("запрос %d: статус %d", i+1, resp.StatusCode)
+		}
+		if resp.ProtoMajor != 2 {
+			t.Errorf("запрос %d: протокол %s, ожидался HTTP/2", i+1, resp.Proto)
+		}
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("открыто соединений: %d, ожидалось одно переиспользуемое", n)
+	}
+}
@@ -2,7 +2,9 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // Сообщение от прикладного уровня
@@ -17,6 +20,7 @@ type SendRequest struct {
 	Sender		string		`json:"sender"`
 	SendTime	time.Time	`json:"send_time"`
 	Payload		string		`json:"data"`
+	Recipient	string		`json:"recipient,omitempty"`	// Необязательный получатель для фильтрации подписок
 }
 
 // Сообщение канальному уровня
@@ -26,52 +30,119 @@ type Segment struct {
 	Sender			string		`json:"sender"`
 	SendTime		time.Time	`json:"send_time"`
 	SegmentPayload	string		`json:"payload"`
+	Recipient		string		`json:"recipient,omitempty"`
+	Type			string		`json:"type,omitempty"`	// Тип сегмента: пусто - часть сообщения, "receipt" - квитанция
 }
 
-// Функция для разделения сообщения на сегменты
+// SegmentSize должен вмещать любой символ UTF-8; при меньшем значении сборка не компилируется
+const _ = uint(SegmentSize - utf8.UTFMax)
+
+// Функция для разделения сообщения на сегменты не длиннее segmentSize байт.
+// Границы сегментов не разрывают многобайтовые символы UTF-8. Если segmentSize меньше
+// длины символа, символ разрезается по байтам: лимит размера соблюдается всегда.
 func splitSegment(payload string, segmentSize int) []string {
 	result := make([]string, 0)
 
-	length := len(payload) // длина сообщения в байтах
-	segmentCount := (length + segmentSize - 1) / segmentSize
-
-	for i := 0; i < segmentCount; i++ {
-		result = append(result, payload[i*segmentSize:min((i+1)*segmentSize, length)])
+	for len(payload) > 0 {
+		limit := min(segmentSize, len(payload))
+		// Отступаем к началу символа не дальше длины одного символа (для некорректного UTF-8 режем по лимиту)
+		end := limit
+		for end > 0 && end < len(payload) && end > limit-utf8.UTFMax && !utf8.RuneStart(payload[end]) {
+			end--
+		}
+		if end < len(payload) && !utf8.RuneStart(payload[end]) {
+			end = limit
+		}
+		if end == 0 {
+			end = limit
+		}
+		result = append(result, payload[:end])
+		payload = payload[end:]
 	}
 
 	return result
 }
 
-// Функция для отправки сегмента на канальный уровень
-func sendSegment(url string, body Segment, wg *sync.WaitGroup, errors chan error) {
-    defer wg.Done()
+// channelStatusError - Канальный уровень ответил статусом, отличным от 200
+type channelStatusError struct {
+    segment int
+    status  string
+    code    int
+    msg     string
+}
+
+func (e *channelStatusError) Error() string {
+    return fmt.Sprintf("сегмент %d не отправлен: %s, ошибка: %s", e.segment, e.status, e.msg)
+}
 
+// Функция для отправки сегмента на канальный уровень; запрос прерывается при отмене ctx.
+// Адрес выбирается из пула channelPool; при недоступности адреса сегмент передается на следующий.
+func sendSegmentOnce(ctx context.Context, body Segment) error {
     // Сериализация структуры в JSON
     payload, err := json.Marshal(body)
     if err != nil {
-        errors <- fmt.Errorf("ошибка сериализации сегмента: %v", err)
-        return
+        return fmt.Errorf("ошибка сериализации сегмента: %v", err)
     }
 
     log.Printf("[<-] Отправка сегмента: %s", string(payload))
 
-    // Отправляем POST-запрос
-    resp, err := http.Post(url, "application/json", bytes.NewBuffer(payload))
+    pool, err := channelPool()
     if err != nil {
-        errors <- fmt.Errorf("ошибка отправки запроса: %v", err)
-        return
+        return err
+    }
+    key := messageKey(body.Sender, body.SendTime)
+    tried := make(map[*channelEndpoint]bool)
+    for {
+        endpoint := pool.pick(key, tried, time.Now())
+        tried[endpoint] = true
+        endpoint.inFlight.Add(1)
+        err = postSegment(ctx, endpoint.url, body, payload)
+        endpoint.inFlight.Add(-1)
+        outcome := segmentOutcome(ctx, err)
+        pool.observe(endpoint, outcome, err, time.Now())
+
+        // Ошибки соединения, 5xx и разомкнутый выключатель - повтор на другом адресе пула
+        if err == nil || (outcome != breakerFailure && !errors.Is(err, errCircuitOpen)) || len(tried) >= len(pool.endpoints) {
+            break
+        }
+        channelPoolMetrics.Add(endpoint.url+".failover", 1)
+        log.Printf("Сегмент %d не отправлен на %s, повтор на другом адресе: %v", body.SegmentNumber, endpoint.url, err)
+    }
+    return err
+}
+
+// postSegment отправляет сериализованный сегмент по адресу url через выключатель адреса
+func postSegment(ctx context.Context, url string, body Segment, payload []byte) error {
+    // Отправляем POST-запрос общим клиентом
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+    if err != nil {
+        return fmt.Errorf("ошибка создания запроса: %v", err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    // Выключатель отклоняет запрос к недоступному канальному уровню без попытки соединения
+    breaker := breakerFor(url)
+    generation, err := breaker.allow(time.Now())
+    if err != nil {
+        return fmt.Errorf("сегмент %d не отправлен на %s: %w", body.SegmentNumber, url, err)
+    }
+    resp, err := outboundHTTP.Do(req)
+    if err != nil {
+        breaker.record(generation, breakerResult(err, 0, ctx.Err() != nil), time.Now())
+        return fmt.Errorf("ошибка отправки запроса: %v", err)
     }
     defer resp.Body.Close()
+    breaker.record(generation, breakerResult(nil, resp.StatusCode, false), time.Now())
 
     if resp.StatusCode == http.StatusOK {
-        log.Printf("Сегмент %v отправлен успешно, статус: %s", body, resp.Status)
-        return
+        io.Copy(io.Discard, resp.Body) // Соединение возвращается в пул только после чтения тела
+        log.Printf("Сегмент %v отправлен успешно на %s, статус: %s", body, url, resp.Status)
+        return nil
     }
 
     respBody, err := io.ReadAll(resp.Body)
     if err != nil {
-        errors <- fmt.Errorf("сегмент %d не отправлен: %s, ошибка чтения ответа: %v", body.SegmentNumber, resp.Status, err)
-        return
+        return fmt.Errorf("сегмент %d не отправлен: %s, ошибка чтения ответа: %v", body.SegmentNumber, resp.Status, err)
     }
 
     var errResp struct {
@@ -81,7 +152,21 @@ func sendSegment(url string, body Segment, wg *sync.WaitGroup, errors chan error
     if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != "" {
         msg = errResp.Error
     }
-    errors <- fmt.Errorf("сегмент %d не отправлен: %s, ошибка: %s", body.SegmentNumber, resp.Status, msg)
+    return &channelStatusError{segment: body.SegmentNumber, status: resp.Status, code: resp.StatusCode, msg: msg}
+}
+
+// segmentOutcome определяет результат отправки сегмента для выключателя и состояния адреса пула
+func segmentOutcome(ctx context.Context, err error) breakerOutcome {
+    var statusErr *channelStatusError
+    switch {
+    case err == nil:
+        return breakerSuccess
+    case ctx.Err() != nil || errors.Is(err, errCircuitOpen):
+        return breakerIgnore
+    case errors.As(err, &statusErr):
+        return breakerResult(nil, statusErr.code, false)
+    }
+    return breakerFailure
 }
 
 // Обработчик POST-запросов от прикладного уровня
@@ -107,43 +192,82 @@ func HandleSend(w http.ResponseWriter, r *http.Request) {
     }
     log.Printf("[->] Полученные данные от прикладного уровня: %+v", message)
 
+    // Ограничение частоты запросов по IP-адресу клиента и по отправителю; токены списываются,
+    // только если соблюдены оба лимита
+    release, ok := limitRequest(w, sendLimiter, len(req), "ip:"+clientIP(r), "sender:"+message.Sender)
+    if !ok {
+        return
+    }
+    defer release()
+
+    // Канальный уровень недоступен: отказ сразу, без ожидания таймаутов соединения
+    if rejectChannelUnavailable(w) {
+        return
+    }
+
     // Разделяем на сегменты
     payloadSegments := splitSegment(message.Payload, SegmentSize)
     totalSegments := len(payloadSegments)
+    messageID := messageKey(message.Sender, message.SendTime)
 
-    var wg sync.WaitGroup
-    errors := make(chan error, totalSegments)
-
-    // Отправляем каждый сегмент асинхронно
+    segments := make([]Segment, totalSegments)
     for i, payload := range payloadSegments {
-        segment := Segment{
+        segments[i] = Segment{
             SegmentNumber:  i + 1,
             TotalSegments:  totalSegments,
             Sender:         message.Sender,
             SendTime:       message.SendTime,
             SegmentPayload: payload,
+            Recipient:      message.Recipient,
         }
+    }
 
+    // Асинхронный режим: ответ 202 сразу, сегменты передает пул воркеров
+    if sendAsync(r) {
+        enqueueSendJob(w, message, segments)
+        return
+    }
+    recordSendStatus(message, totalSegments, deliveryPending, "")
+
+    var wg sync.WaitGroup
+    errs := make(chan error, totalSegments)
+
+    // Отправляем каждый сегмент асинхронно; отключение клиента прерывает отправку
+    for _, segment := range segments {
         wg.Add(1)
-        go sendSegment(urlChannelLevel, segment, &wg, errors)
+        go func(segment Segment) {
+            defer wg.Done()
+            if err := sendSegmentOnce(r.Context(), segment); err != nil {
+                errs <- err
+            }
+        }(segment)
     }
 
     wg.Wait()
-    close(errors)
+    close(errs)
 
     var errorMessages []string
-    for err := range errors {
+    circuitOpen := false
+    for err := range errs {
         log.Printf("Ошибка при отправке сегмента: %v", err)
         errorMessages = append(errorMessages, err.Error())
+        circuitOpen = circuitOpen || errors.Is(err, errCircuitOpen)
     }
 
+    w.Header().Set("X-Message-ID", messageID)
     if len(errorMessages) == 0 {
+        recordSendStatus(message, totalSegments, deliverySent, "")
         msg := "Все сегменты успешно отправлены на канальный уровень"
         w.WriteHeader(http.StatusOK)
         fmt.Fprintln(w, msg)
         log.Println(msg)
     } else {
-        http.Error(w, strings.Join(errorMessages, "\n"), http.StatusInternalServerError)
+        recordSendStatus(message, totalSegments, deliverySendFailed, strings.Join(errorMessages, "; "))
+        status := http.StatusInternalServerError
+        if circuitOpen {
+            status = http.StatusServiceUnavailable
+        }
+        http.Error(w, strings.Join(errorMessages, "\n"), status)
     }
 
 }
\ No newline at end of file
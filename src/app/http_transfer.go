@@ -3,10 +3,12 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/segmentio/kafka-go"
@@ -17,16 +19,20 @@ func logErrorAndSend(errChan chan<- error, msg string, err error) {
 	errChan <- fmt.Errorf(msg, err)
 }
 
+// segmentProducer - Функция записи сегмента в брокер; в тестах заменяется шиной в памяти
+var segmentProducer = produceSegment
+
 // Функция для продюсера Kafka
 func produceSegment(segment Segment, errChan chan<- error) {
 	defer close(errChan)
 	log.Printf("Горутина продюсера запущена для сегмента #%d", segment.SegmentNumber)
 
 	// Создаем писатель Kafka.
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{KafkaAddr}, // Адрес брокера(ов) Kafka
-		Topic:   KafkaTopic,          // Топик Kafka
-	})
+	writer, err := newKafkaWriter(KafkaTopic)
+	if err != nil {
+		logErrorAndSend(errChan, "Ошибка настройки подключения к Kafka: %v", err)
+		return
+	}
 
 	// Закрываем писателя при завершении функции
 	defer func() {
@@ -44,8 +50,10 @@ func produceSegment(segment Segment, errChan chan<- error) {
 		return
 	}
 
-	// Создаем сообщение Kafka.
+	// Создаем сообщение Kafka. Ключ сообщения направляет все его сегменты в один раздел,
+	// поэтому их собирает один consumer группы.
 	msg := kafka.Message{
+		Key:   segmentKey(segment),
 		Value: segmentBytes,
 	}
 
@@ -64,6 +72,11 @@ func produceSegment(segment Segment, errChan chan<- error) {
 	errChan <- nil
 }
 
+// segmentKey возвращает ключ записи Kafka для сегмента - ключ собираемого сообщения
+func segmentKey(segment Segment) []byte {
+	return []byte(messageKey(segment.Sender, segment.SendTime))
+}
+
 // Обработчик POST-запросов от канального уровня
 func HandleTransfer(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
@@ -88,10 +101,53 @@ func HandleTransfer(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[->] Полученные данные от канального уровня: %+v", segment)
 
+	// Квитанции о доставке не записываются в Kafka, а обновляют статус отправленного сообщения
+	switch segment.Type {
+	case segmentData:
+	case segmentReceipt:
+		handleReceipt(w, segment)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("Неизвестный тип сегмента %q", segment.Type), http.StatusBadRequest)
+		return
+	}
+
+	// Повторы уже принятых сегментов и сегменты завершенных сообщений не записываются в Kafka повторно
+	now := time.Now()
+	identity := segmentIdentity(segment)
+	if acceptedSegments.Contains(identity, now) || finishedMessages.Contains(messageKey(segment.Sender, segment.SendTime), now) {
+		dedupMetrics.Add("transfer", 1)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Сегмент уже принят")
+		log.Printf("Отброшен повтор сегмента %d/%d от '%s'", segment.SegmentNumber, segment.TotalSegments, segment.Sender)
+		return
+	}
+
+	// Ограничение частоты запросов по отправителю. Лимита по IP нет: все сегменты приходят
+	// от канального уровня, и общий лимит по его адресу ограничивал бы весь узел.
+	releaseSender, ok := limitRequest(w, transferLimiter, len(req), "sender:"+segment.Sender)
+	if !ok {
+		return
+	}
+	defer releaseSender()
+
+	// Проверка лимитов сборки до записи сегмента в Kafka
+	if err := reassembler.Check(segment); err != nil {
+		status := http.StatusTooManyRequests
+		if errors.Is(err, ErrTooManySegments) || errors.Is(err, ErrMessageTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		} else {
+			w.Header().Set("Retry-After", strconv.Itoa(int(MaxInactivityCycles.Seconds())))
+		}
+		http.Error(w, fmt.Sprintf("Сегмент отклонен: %v", err), status)
+		log.Printf("Сегмент %d/%d от '%s' отклонен: %v", segment.SegmentNumber, segment.TotalSegments, segment.Sender, err)
+		return
+	}
+
 	// Создаем канал для получения ошибки от горутины продюсера.
 	errChan := make(chan error, 1)
 
-	go produceSegment(segment, errChan)
+	go segmentProducer(segment, errChan)
 
 	producerErr := <-errChan
 
@@ -101,6 +157,7 @@ func HandleTransfer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Ошибка записи сегмента в брокер Kafka: %v", producerErr), http.StatusInternalServerError)
 		return
 	}
+	acceptedSegments.Add(identity, now)
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "Сегмент принят и успешно отправлен в Kafka")
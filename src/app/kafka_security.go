@@ -0,0 +1,174 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Поддерживаемые механизмы SASL
+const (
+	saslPlain       = "PLAIN"
+	saslScramSHA256 = "SCRAM-SHA-256"
+	saslScramSHA512 = "SCRAM-SHA-512"
+)
+
+// kafkaTLSEnabled сообщает, нужно ли подключаться к Kafka по TLS
+func kafkaTLSEnabled() bool {
+	return KafkaTLS || KafkaTLSCA != "" || KafkaTLSCert != ""
+}
+
+// kafkaSecurityProtocol возвращает значение security.protocol для librdkafka
+func kafkaSecurityProtocol() string {
+	switch {
+	case kafkaTLSEnabled() && KafkaSASLMechanism != "":
+		return "sasl_ssl"
+	case kafkaTLSEnabled():
+		return "ssl"
+	case KafkaSASLMechanism != "":
+		return "sasl_plaintext"
+	}
+	return "plaintext"
+}
+
+// checkKafkaSecurity проверяет согласованность параметров TLS и SASL
+func checkKafkaSecurity() error {
+	switch strings.ToUpper(KafkaSASLMechanism) {
+	case "":
+	case saslPlain, saslScramSHA256, saslScramSHA512:
+		if KafkaSASLUsername == "" {
+			return fmt.Errorf("для SASL %s не задан TRANSPORT_KAFKA_SASL_USERNAME", KafkaSASLMechanism)
+		}
+	default:
+		return fmt.Errorf("неподдерживаемый механизм SASL %q (поддерживаются %s, %s, %s)",
+			KafkaSASLMechanism, saslPlain, saslScramSHA256, saslScramSHA512)
+	}
+	if (KafkaTLSCert == "") != (KafkaTLSKey == "") {
+		return fmt.Errorf("клиентский сертификат и ключ Kafka задаются вместе (TRANSPORT_KAFKA_TLS_CERT, TRANSPORT_KAFKA_TLS_KEY)")
+	}
+	return nil
+}
+
+// kafkaConfigMap возвращает настройки клиента confluent: адрес брокера, TLS и SASL, дополненные values.
+// librdkafka, как и писатели segmentio, проверяет сертификат по имени брокера из адреса.
+func kafkaConfigMap(values confluent.ConfigMap) (*confluent.ConfigMap, error) {
+	if err := checkKafkaSecurity(); err != nil {
+		return nil, err
+	}
+
+	config := confluent.ConfigMap{
+		"bootstrap.servers": KafkaAddr,
+		"security.protocol": kafkaSecurityProtocol(),
+	}
+	if kafkaTLSEnabled() {
+		config["ssl.endpoint.identification.algorithm"] = "https"
+		if KafkaTLSCA != "" {
+			config["ssl.ca.location"] = KafkaTLSCA
+		}
+		if KafkaTLSCert != "" {
+			config["ssl.certificate.location"] = KafkaTLSCert
+			config["ssl.key.location"] = KafkaTLSKey
+		}
+	}
+	if KafkaSASLMechanism != "" {
+		config["sasl.mechanism"] = strings.ToUpper(KafkaSASLMechanism)
+		config["sasl.username"] = KafkaSASLUsername
+		config["sasl.password"] = KafkaSASLPassword
+	}
+	for key, value := range values {
+		config[key] = value
+	}
+	return &config, nil
+}
+
+// kafkaTLSConfig собирает настройки TLS для клиента segmentio; nil, если TLS выключен
+func kafkaTLSConfig() (*tls.Config, error) {
+	if !kafkaTLSEnabled() {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if KafkaTLSCA != "" {
+		pem, err := os.ReadFile(KafkaTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать сертификаты CA Kafka: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("в файле %s нет PEM сертификатов", KafkaTLSCA)
+		}
+	}
+	if KafkaTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(KafkaTLSCert, KafkaTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось загрузить клиентский сертификат Kafka: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// kafkaSASLMechanism создает механизм SASL для клиента segmentio; nil, если SASL выключен
+func kafkaSASLMechanism() (sasl.Mechanism, error) {
+	switch strings.ToUpper(KafkaSASLMechanism) {
+	case "":
+		return nil, nil
+	case saslPlain:
+		return plain.Mechanism{Username: KafkaSASLUsername, Password: KafkaSASLPassword}, nil
+	case saslScramSHA256:
+		return scram.Mechanism(scram.SHA256, KafkaSASLUsername, KafkaSASLPassword)
+	case saslScramSHA512:
+		return scram.Mechanism(scram.SHA512, KafkaSASLUsername, KafkaSASLPassword)
+	}
+	return nil, fmt.Errorf("неподдерживаемый механизм SASL %q", KafkaSASLMechanism)
+}
+
+// Общий транспорт писателей segmentio: соединения с брокером переиспользуются между запросами
+var (
+	kafkaTransportOnce sync.Once
+	kafkaTransportVal  *kafka.Transport
+	kafkaTransportErr  error
+)
+
+// kafkaTransport возвращает транспорт segmentio с настройками TLS и SASL
+func kafkaTransport() (*kafka.Transport, error) {
+	kafkaTransportOnce.Do(func() {
+		if kafkaTransportErr = checkKafkaSecurity(); kafkaTransportErr != nil {
+			return
+		}
+		transport := &kafka.Transport{DialTimeout: 5 * time.Second}
+		if transport.TLS, kafkaTransportErr = kafkaTLSConfig(); kafkaTransportErr != nil {
+			return
+		}
+		if transport.SASL, kafkaTransportErr = kafkaSASLMechanism(); kafkaTransportErr != nil {
+			return
+		}
+		kafkaTransportVal = transport
+	})
+	return kafkaTransportVal, kafkaTransportErr
+}
+
+// newKafkaWriter создает писателя в топик с общими настройками подключения
+func newKafkaWriter(topic string) (*kafka.Writer, error) {
+	transport, err := kafkaTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(strings.Split(KafkaAddr, ",")...),
+		Topic:                  topic,
+		AllowAutoTopicCreation: KafkaAutoCreateTopics, // При отключенном создании запись в отсутствующий топик - ошибка
+		Balancer:               &kafka.Hash{},         // Записи с одним ключом попадают в один раздел
+		Transport:              transport,
+	}, nil
+}
@@ -0,0 +1,103 @@
+//go:build integration
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// TestKafkaSecureRoundTrip записывает сообщение писателем segmentio и читает его consumer-ом confluent
+// с параметрами подключения из окружения. Брокер с SASL поднимается docker-compose (порт 29093):
+//
+//	TRANSPORT_KAFKA_ADDR=localhost:29093 TRANSPORT_KAFKA_SASL_MECHANISM=SCRAM-SHA-256 \
+//	TRANSPORT_KAFKA_SASL_USERNAME=transport TRANSPORT_KAFKA_SASL_PASSWORD=transport-secret \
+//	go test -tags integration -run TestKafkaSecureRoundTrip ./src/app
+func TestKafkaSecureRoundTrip(t *testing.T) {
+	topic := fmt.Sprintf("segments-security-test-%d", time.Now().UnixNano())
+	value := []byte(fmt.Sprintf("проверка %s", kafkaSecurityProtocol()))
+
+	// Первая запись может не пройти, пока брокер создает топик
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = writeKafka(topic, nil, value); err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		t.Fatalf("запись в %s через %s: %v", topic, KafkaAddr, err)
+	}
+
+	config, err := kafkaConfigMap(confluent.ConfigMap{
+		"group.id":           topic,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := confluent.NewConsumer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	if err := consumer.SubscribeTopics([]string{topic}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for ctx.Err() == nil {
+		msg, err := consumer.ReadMessage(time.Second)
+		if err != nil {
+			continue
+		}
+		if string(msg.Value) != string(value) {
+			t.Fatalf("прочитано %q, ожидалось %q", msg.Value, value)
+		}
+		return
+	}
+	t.Fatalf("сообщение не прочитано из %s за 30s", topic)
+}
+
+// TestKafkaTLSVerifiesBroker проверяет, что без CA брокера TLS подключение отклоняется обоими клиентами.
+// Запускается с TLS листенером из docker-compose (порт 29094):
+//
+//	TRANSPORT_KAFKA_ADDR=localhost:29094 TRANSPORT_KAFKA_TLS_CA=$PWD/kafka/tls/ca.pem \
+//	go test -tags integration -run TestKafkaTLSVerifiesBroker ./src/app
+func TestKafkaTLSVerifiesBroker(t *testing.T) {
+	if KafkaTLSCA == "" {
+		t.Skip("TRANSPORT_KAFKA_TLS_CA не задан")
+	}
+	savedCA, savedTLS := KafkaTLSCA, KafkaTLS
+	t.Cleanup(func() {
+		KafkaTLSCA, KafkaTLS = savedCA, savedTLS
+		kafkaTransportOnce = sync.Once{}
+	})
+	// Системные корневые сертификаты не содержат тестовый CA
+	KafkaTLSCA, KafkaTLS = "", true
+	kafkaTransportOnce = sync.Once{}
+
+	if err := writeKafka(fmt.Sprintf("segments-tls-test-%d", time.Now().UnixNano()), nil, []byte("x")); err == nil {
+		t.Error("писатель подключился без CA брокера")
+	}
+
+	config, err := kafkaConfigMap(confluent.ConfigMap{"group.id": "segments-tls-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := confluent.NewConsumer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	if _, err := consumer.GetMetadata(nil, true, 5000); err == nil {
+		t.Error("consumer подключился без CA брокера")
+	}
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// withKafkaSecurity задает параметры подключения к Kafka на время теста
+func withKafkaSecurity(t *testing.T, tlsOn bool, ca, cert, key, mechanism, username string) {
+	t.Helper()
+	saved := []string{KafkaTLSCA, KafkaTLSCert, KafkaTLSKey, KafkaSASLMechanism, KafkaSASLUsername, KafkaSASLPassword}
+	savedTLS := KafkaTLS
+	t.Cleanup(func() {
+		KafkaTLS = savedTLS
+		KafkaTLSCA, KafkaTLSCert, KafkaTLSKey = saved[0], saved[1], saved[2]
+		KafkaSASLMechanism, KafkaSASLUsername, KafkaSASLPassword = saved[3], saved[4], saved[5]
+	})
+	KafkaTLS = tlsOn
+	KafkaTLSCA, KafkaTLSCert, KafkaTLSKey = ca, cert, key
+	KafkaSASLMechanism, KafkaSASLUsername, KafkaSASLPassword = mechanism, username, "secret"
+}
+
+func TestKafkaConfigMap(t *testing.T) {
+	tests := []struct {
+		name      string
+		tls       bool
+		ca        string
+		cert, key string
+		mechanism string
+		username  string
+		want      map[string]string
+		wantErr   bool
+	}{
+		{
+			name: "без защиты",
+			want: map[string]string{"security.protocol": "plaintext"},
+		},
+		{
+			name: "TLS с CA",
+			ca:   "/certs/ca.pem",
+			want: map[string]string{"security.protocol": "ssl", "ssl.ca.location": "/certs/ca.pem", "ssl.endpoint.identification.algorithm": "https"},
+		},
+		{
+			name: "mTLS",
+			tls:  true,
+			cert: "/certs/client.pem",
+			key:  "/certs/client.key",
+			want: map[string]string{"security.protocol": "ssl", "ssl.certificate.location": "/certs/client.pem", "ssl.key.location": "/certs/client.key"},
+		},
+		{
+			name:      "SASL PLAIN",
+			mechanism: "plain",
+			username:  "transport",
+			want:      map[string]string{"security.protocol": "sasl_plaintext", "sasl.mechanism": "PLAIN", "sasl.username": "transport"},
+		},
+		{
+			name:      "SASL SCRAM по TLS",
+			tls:       true,
+			mechanism: "SCRAM-SHA-512",
+			username:  "transport",
+			want:      map[string]string{"security.protocol": "sasl_ssl", "sasl.mechanism": "SCRAM-SHA-512"},
+		},
+		{name: "неизвестный механизм", mechanism: "GSSAPI", username: "transport", wantErr: true},
+		{name: "SASL без имени", mechanism: "PLAIN", wantErr: true},
+		{name: "сертификат без ключа", cert: "/certs/client.pem", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withKafkaSecurity(t, tt.tls, tt.ca, tt.cert, tt.key, tt.mechanism, tt.username)
+			config, err := kafkaConfigMap(nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ожидалась ошибка")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if got := (*config)[key]; got != want {
+					t.Errorf("%s = %v, ожидалось %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaTransport(t *testing.T) {
+	dir := t.TempDir()
+	caFile, certFile, keyFile := writeTestCertificate(t, dir)
+
+	withKafkaSecurity(t, true, caFile, certFile, keyFile, "PLAIN", "transport")
+	tlsConfig, err := kafkaTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 {
+		t.Errorf("CA или клиентский сертификат не загружены: %+v", tlsConfig)
+	}
+	mechanism, err := kafkaSASLMechanism()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := mechanism.(plain.Mechanism); !ok || m.Username != "transport" {
+		t.Errorf("механизм SASL = %#v", mechanism)
+	}
+
+	for _, name := range []string{"SCRAM-SHA-256", "SCRAM-SHA-512"} {
+		KafkaSASLMechanism = name
+		if mechanism, err := kafkaSASLMechanism(); err != nil || mechanism.Name() != name {
+			t.Errorf("%s: механизм %v, ошибка %v", name, mechanism, err)
+		}
+	}
+
+	KafkaTLSCA = filepath.Join(dir, "missing.pem")
+	if _, err := kafkaTLSConfig(); err == nil {
+		t.Error("ожидалась ошибка для отсутствующего файла CA")
+	}
+}
+
+// writeTestCertificate создает самоподписанный сертификат, используемый и как CA, и как клиентский
+func writeTestCertificate(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafka-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, certFile, keyFile
+}
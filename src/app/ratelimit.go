@@ -0,0 +1,242 @@
+package app
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Параметры ограничения частоты запросов одного маршрута. Нулевое значение поля отключает соответствующий лимит.
+type RateLimitConfig struct {
+	MessagesPerSec float64 // Запросов в секунду
+	Burst          int     // Допустимый всплеск запросов
+	BytesPerSec    int     // Байт тела запроса в секунду (емкость корзины - одна секунда)
+	MaxConcurrent  int     // Одновременно обрабатываемых запросов
+}
+
+// Метрики ограничения частоты запросов (доступны через /debug/vars)
+var rateLimitMetrics = expvar.NewMap("ratelimit")
+
+// Ограничители маршрутов; ключи - "sender:<отправитель>" и "ip:<адрес клиента>" (только /send:
+// запросы /transfer приходят от канального уровня с небольшого числа адресов)
+var (
+	sendLimiter     = NewRouteLimiter("send", SendRateLimit)
+	transferLimiter = NewRouteLimiter("transfer", TransferRateLimit)
+)
+
+// tokenBucket - Корзина токенов с пополнением rate токенов в секунду до capacity
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate, capacity float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, capacity: capacity, tokens: capacity, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait возвращает время до появления n токенов (0 - токенов достаточно).
+// Запрос больше емкости корзины допускается при полной корзине и уводит баланс в минус.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	need := math.Min(n, b.capacity)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limiterEntry - Состояние лимитов для одного ключа (отправителя или IP)
+type limiterEntry struct {
+	messages *tokenBucket
+	bytes    *tokenBucket
+	active   int
+	lastSeen time.Time
+}
+
+// RouteLimiter - Ограничитель частоты запросов одного маршрута по ключам
+type RouteLimiter struct {
+	route   string
+	config  RateLimitConfig
+	mu      sync.Mutex
+	entries map[string]*limiterEntry
+	sweepAt time.Time
+}
+
+// NewRouteLimiter создает ограничитель; при нулевой конфигурации возвращает nil (ограничения нет)
+func NewRouteLimiter(route string, config RateLimitConfig) *RouteLimiter {
+	if config == (RateLimitConfig{}) {
+		return nil
+	}
+	return &RouteLimiter{route: route, config: config, entries: make(map[string]*limiterEntry)}
+}
+
+// acquire проверяет лимиты всех ключей и только если все они соблюдены, списывает токены
+// и занимает слоты одновременной обработки. При превышении возвращает причину и рекомендуемое
+// время повтора; токены других ключей при этом не тратятся.
+func (l *RouteLimiter) acquire(keys []string, size int, now time.Time) (release func(), retryAfter time.Duration, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	entries := make([]*limiterEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = l.entry(key, now)
+		if retryAfter, reason = entries[i].check(l.config, size, now); reason != "" {
+			return nil, retryAfter, reason
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.messages != nil {
+			entry.messages.tokens--
+		}
+		if entry.bytes != nil {
+			entry.bytes.tokens -= float64(size)
+		}
+		entry.active++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			for _, entry := range entries {
+				entry.active--
+			}
+			l.mu.Unlock()
+		})
+	}, 0, ""
+}
+
+// entry возвращает состояние лимитов ключа, создавая его при первом запросе. Вызывается под мьютексом.
+func (l *RouteLimiter) entry(key string, now time.Time) *limiterEntry {
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &limiterEntry{}
+		if l.config.MessagesPerSec > 0 {
+			entry.messages = newTokenBucket(l.config.MessagesPerSec, float64(max(l.config.Burst, 1)), now)
+		}
+		if l.config.BytesPerSec > 0 {
+			entry.bytes = newTokenBucket(float64(l.config.BytesPerSec), float64(l.config.BytesPerSec), now)
+		}
+		l.entries[key] = entry
+	}
+	entry.lastSeen = now
+	return entry
+}
+
+// check проверяет лимиты ключа для запроса размером size, не списывая токены
+func (e *limiterEntry) check(config RateLimitConfig, size int, now time.Time) (time.Duration, string) {
+	if config.MaxConcurrent > 0 && e.active >= config.MaxConcurrent {
+		return time.Second, "concurrent"
+	}
+	if e.messages != nil {
+		e.messages.refill(now)
+		if wait := e.messages.wait(1); wait > 0 {
+			return wait, "messages"
+		}
+	}
+	if e.bytes != nil {
+		e.bytes.refill(now)
+		if wait := e.bytes.wait(float64(size)); wait > 0 {
+			return wait, "bytes"
+		}
+	}
+	return 0, ""
+}
+
+// sweep удаляет давно неактивные ключи, чтобы таблица не росла неограниченно. Вызывается под мьютексом.
+func (l *RouteLimiter) sweep(now time.Time) {
+	if now.Before(l.sweepAt) {
+		return
+	}
+	l.sweepAt = now.Add(time.Minute)
+	for key, entry := range l.entries {
+		if entry.active == 0 && now.Sub(entry.lastSeen) > 10*time.Minute {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// limitRequest проверяет лимиты маршрута для ключей keys. При превышении отвечает 429 с Retry-After
+// и возвращает ok=false; иначе возвращает функцию освобождения слотов одновременной обработки.
+func limitRequest(w http.ResponseWriter, l *RouteLimiter, size int, keys ...string) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	release, retryAfter, reason := l.acquire(keys, size, time.Now())
+	if release != nil {
+		rateLimitMetrics.Add(l.route+".allowed", 1)
+		return release, true
+	}
+
+	rateLimitMetrics.Add(fmt.Sprintf("%s.rejected.%s", l.route, reason), 1)
+	log.Printf("Превышен лимит '%s' маршрута %s для %s", reason, l.route, strings.Join(keys, ", "))
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, fmt.Sprintf("Превышен лимит запросов (%s), повторите позже", reason), http.StatusTooManyRequests)
+	return nil, false
+}
+
+// clientIP возвращает IP-адрес клиента из RemoteAddr
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// envRateLimit возвращает конфигурацию ограничения из переменной окружения или значение по умолчанию
+func envRateLimit(key, def string) RateLimitConfig {
+	value := envString(key, def)
+	config, err := parseRateLimit(value)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %q: %v", key, value, def, err)
+		config, _ = parseRateLimit(def)
+	}
+	return config
+}
+
+// parseRateLimit разбирает конфигурацию вида "msgs=10,burst=20,bytes=65536,concurrent=4".
+// Значение "off" отключает ограничение маршрута.
+func parseRateLimit(value string) (RateLimitConfig, error) {
+	var config RateLimitConfig
+	if value == "off" || value == "" {
+		return config, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		name, raw, _ := strings.Cut(strings.TrimSpace(part), "=")
+		var err error
+		switch name {
+		case "msgs":
+			config.MessagesPerSec, err = strconv.ParseFloat(raw, 64)
+		case "burst":
+			config.Burst, err = strconv.Atoi(raw)
+		case "bytes":
+			config.BytesPerSec, err = strconv.Atoi(raw)
+		case "concurrent":
+			config.MaxConcurrent, err = strconv.Atoi(raw)
+		default:
+			err = fmt.Errorf("неизвестный параметр %q", name)
+		}
+		if err != nil {
+			return RateLimitConfig{}, err
+		}
+	}
+	return config, nil
+}
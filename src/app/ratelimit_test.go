@@ -0,0 +1,136 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    RateLimitConfig
+		wantErr bool
+	}{
+		{value: "off"},
+		{value: ""},
+		{value: "msgs=2.5,burst=4,bytes=100,concurrent=3", want: RateLimitConfig{MessagesPerSec: 2.5, Burst: 4, BytesPerSec: 100, MaxConcurrent: 3}},
+		{value: "msgs=1, concurrent=2", want: RateLimitConfig{MessagesPerSec: 1, MaxConcurrent: 2}},
+		{value: "msgs=x", wantErr: true},
+		{value: "rps=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRateLimit(%q) = %+v, %v", tt.value, got, err)
+		}
+	}
+	if NewRouteLimiter("test", RateLimitConfig{}) != nil {
+		t.Error("ограничитель без лимитов должен быть nil")
+	}
+}
+
+func TestRouteLimiterAcquire(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	type step struct {
+		at         time.Duration
+		keys       []string
+		size       int
+		reason     string        // Пусто - запрос пропущен
+		retryAfter time.Duration // Для отклоненных запросов
+	}
+	tests := []struct {
+		name   string
+		config RateLimitConfig
+		steps  []step
+	}{
+		{
+			name:   "корзина запросов",
+			config: RateLimitConfig{MessagesPerSec: 2, Burst: 2},
+			steps: []step{
+				{keys: []string{"a"}},
+				{keys: []string{"a"}},
+				{keys: []string{"a"}, reason: "messages", retryAfter: 500 * time.Millisecond},
+				{keys: []string{"b"}}, // У другого ключа своя корзина
+				{at: 500 * time.Millisecond, keys: []string{"a"}},
+			},
+		},
+		{
+			name:   "корзина байт",
+			config: RateLimitConfig{BytesPerSec: 100},
+			steps: []step{
+				{keys: []string{"a"}, size: 60},
+				{keys: []string{"a"}, size: 60, reason: "bytes", retryAfter: 200 * time.Millisecond},
+				{at: 200 * time.Millisecond, keys: []string{"a"}, size: 60},
+				{at: 10 * time.Second, keys: []string{"a"}, size: 500}, // Больше емкости допускается при полной корзине
+				{at: 10 * time.Second, keys: []string{"a"}, size: 1, reason: "bytes", retryAfter: 4010 * time.Millisecond},
+			},
+		},
+		{
+			name:   "токены не списываются, если превышен лимит другого ключа",
+			config: RateLimitConfig{MessagesPerSec: 1, Burst: 1},
+			steps: []step{
+				{keys: []string{"sender:a"}},
+				{keys: []string{"ip:1", "sender:a"}, reason: "messages", retryAfter: time.Second},
+				{keys: []string{"ip:1", "sender:b"}}, // Токен ip:1 не был потрачен отклоненным запросом
+				{keys: []string{"ip:1", "sender:c"}, reason: "messages", retryAfter: time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRouteLimiter("test", tt.config)
+			for i, s := range tt.steps {
+				release, retryAfter, reason := l.acquire(s.keys, s.size, start.Add(s.at))
+				if reason != s.reason || (reason != "" && retryAfter != s.retryAfter) {
+					t.Fatalf("шаг %d: причина %q, повтор через %s; ожидалось %q, %s", i+1, reason, retryAfter, s.reason, s.retryAfter)
+				}
+				if (release == nil) != (s.reason != "") {
+					t.Fatalf("шаг %d: функция освобождения не соответствует результату", i+1)
+				}
+				if release != nil {
+					release()
+				}
+			}
+		})
+	}
+}
+
+func TestRouteLimiterConcurrency(t *testing.T) {
+	l := NewRouteLimiter("test", RateLimitConfig{MaxConcurrent: 1})
+	now := time.Now()
+	release, _, _ := l.acquire([]string{"ip:1", "sender:a"}, 0, now)
+	if _, _, reason := l.acquire([]string{"sender:a"}, 0, now); reason != "concurrent" {
+		t.Fatalf("второй одновременный запрос: %q", reason)
+	}
+	release()
+	release() // Повторный вызов не освобождает чужой слот
+	second, _, _ := l.acquire([]string{"sender:a"}, 0, now)
+	if second == nil {
+		t.Fatal("слот не освобожден")
+	}
+	if _, _, reason := l.acquire([]string{"ip:1"}, 0, now); reason != "" {
+		t.Errorf("слот ip:1 не освобожден: %q", reason)
+	}
+	if _, _, reason := l.acquire([]string{"sender:a"}, 0, now); reason != "concurrent" {
+		t.Errorf("повторный release освободил занятый слот: %q", reason)
+	}
+}
+
+func TestLimitRequestRetryAfter(t *testing.T) {
+	l := NewRouteLimiter("test", RateLimitConfig{MessagesPerSec: 0.4, Burst: 1})
+	if _, ok := limitRequest(httptest.NewRecorder(), l, 0, "sender:a"); !ok {
+		t.Fatal("первый запрос отклонен")
+	}
+	w := httptest.NewRecorder()
+	if _, ok := limitRequest(w, l, 0, "sender:a"); ok {
+		t.Fatal("запрос сверх лимита пропущен")
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("ответ %d, Retry-After %q; ожидалось 429 и 3", w.Code, w.Header().Get("Retry-After"))
+	}
+	if release, ok := limitRequest(httptest.NewRecorder(), nil, 0, "sender:a"); !ok || release == nil {
+		t.Error("без ограничителя запрос должен проходить")
+	}
+}
@@ -0,0 +1,592 @@
+package app
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Структура для хранения состояния сборки одного логического сообщения
+type MessageReassemblyState struct {
+	Segments                map[int]Segment // Хранит полученные сегменты по их номеру
+	TotalSegmentsExpected   int             // Общее количество ожидаемых сегментов
+	FirstSegmentArrivalTime time.Time       // Время поступления первого сегмента для этого сообщения
+	LastSegmentArrivalTime  time.Time       // Время поступления последнего сегмента для этого сообщения
+	PayloadBytes            int             // Суммарный размер полученной полезной нагрузки
+	Sender                  string
+	SendTime                time.Time
+	Recipient               string
+}
+
+// Результат обработки сегмента движком сборки
+type AddStatus int
+
+const (
+	SegmentAdded     AddStatus = iota // Сегмент добавлен, сообщение еще не собрано
+	SegmentCompleted                  // Сегмент добавлен и сообщение полностью собрано
+	SegmentDuplicate                  // Сегмент с таким номером уже получен
+	SegmentMismatch                   // Метаданные сегмента не совпадают с уже полученными
+	SegmentInvalid                    // Некорректный номер сегмента
+	SegmentRejected                   // Сегмент нарушает лимиты; сообщение вытеснено или сегмент не принят
+)
+
+// Ошибки превышения лимитов сборки
+var (
+	ErrTooManySegments = errors.New("превышено максимальное количество сегментов в сообщении")
+	ErrMessageTooLarge = errors.New("превышен максимальный размер сообщения")
+	ErrTooManyInFlight = errors.New("превышено количество незавершенных сообщений")
+	ErrSenderInFlight  = errors.New("превышено количество незавершенных сообщений отправителя")
+	ErrBufferFull      = errors.New("превышен объем буферизованных сегментов")
+)
+
+// Лимиты памяти движка сборки. Нулевое значение означает отсутствие ограничения.
+type ReassemblyLimits struct {
+	MaxSegmentsPerMessage int   // Максимальное значение total_segments
+	MaxMessageBytes       int   // Максимальный размер полезной нагрузки одного сообщения
+	MaxInFlightMessages   int   // Максимальное количество незавершенных сообщений
+	MaxInFlightPerSender  int   // Максимальное количество незавершенных сообщений одного отправителя
+	MaxBufferedBytes      int64 // Максимальный суммарный размер буферизованной полезной нагрузки
+}
+
+// Собранное или несобранное сообщение, покинувшее движок сборки
+type ReassemblyResult struct {
+	Key    string
+	Status string // historyCompleted, historyTimeout и т.д.
+	State  *MessageReassemblyState
+	Output OutputMessage
+}
+
+// activityEntry - Положение незавершенного сообщения в очередях вытеснения
+type activityEntry struct {
+	key      string
+	sender   string
+	global   *list.Element // Элемент очереди всех сообщений
+	bySender *list.Element // Элемент очереди сообщений отправителя
+}
+
+// reassemblyShard - Часть коллекции незавершенных сообщений со своим мьютексом
+type reassemblyShard struct {
+	mu        sync.Mutex
+	messages  map[string]*MessageReassemblyState
+	deadlines *deadlineQueue // Сроки таймаутов сообщений шарда
+}
+
+// Reassembler - Движок сборки сообщений из сегментов. Незавершенные сообщения распределены
+// по шардам по хешу ключа, поэтому сегменты разных сообщений обрабатываются без общей блокировки.
+// Не зависит от Kafka и HTTP; время передается явно для тестируемости.
+//
+// При превышении лимитов ReassemblyLimits вытесняются сообщения, дольше всего не получавшие
+// сегментов (при превышении лимита отправителя - только сообщения этого отправителя).
+// Если вытеснить можно только сообщение самого сегмента, сегмент не принимается.
+type Reassembler struct {
+	shards  []*reassemblyShard
+	timeout time.Duration // Максимальное время ожидания следующего сегмента
+	limits  ReassemblyLimits
+
+	messages atomic.Int64 // Количество незавершенных сообщений
+	bytes    atomic.Int64 // Суммарный размер буферизованной полезной нагрузки
+
+	// Очереди вытеснения: в начале - сообщения, дольше всего не получавшие сегментов.
+	// Выбор вытесняемого сообщения не требует обхода шардов. Захватывается после мьютекса шарда.
+	activityMu sync.Mutex
+	activity   *list.List
+	bySender   map[string]*list.List
+	entries    map[string]*activityEntry
+}
+
+// NewReassembler создает движок сборки с указанным количеством шардов, таймаутом и лимитами
+func NewReassembler(shardCount int, timeout time.Duration, limits ReassemblyLimits) *Reassembler {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	r := &Reassembler{
+		shards:   make([]*reassemblyShard, shardCount),
+		timeout:  timeout,
+		limits:   limits,
+		activity: list.New(),
+		bySender: make(map[string]*list.List),
+		entries:  make(map[string]*activityEntry),
+	}
+	for i := range r.shards {
+		r.shards[i] = &reassemblyShard{
+			messages:  make(map[string]*MessageReassemblyState),
+			deadlines: newDeadlineQueue(),
+		}
+	}
+	return r
+}
+
+// messageKey формирует уникальный ключ сообщения
+func messageKey(sender string, sendTime time.Time) string {
+	return fmt.Sprintf("%s_%s", sender, sendTime.Format(time.RFC3339Nano))
+}
+
+func (r *Reassembler) shard(key string) *reassemblyShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return r.shards[h.Sum32()%uint32(len(r.shards))]
+}
+
+// Add добавляет сегмент. Результаты содержат сообщение, завершенное этим сегментом,
+// и сообщения, вытесненные из-за превышения лимитов.
+func (r *Reassembler) Add(segment Segment, now time.Time) (AddStatus, []ReassemblyResult) {
+	if segment.SegmentNumber < 1 || segment.SegmentNumber > segment.TotalSegments {
+		return SegmentInvalid, nil
+	}
+	if r.limits.MaxSegmentsPerMessage > 0 && segment.TotalSegments > r.limits.MaxSegmentsPerMessage {
+		return SegmentRejected, nil
+	}
+
+	key := messageKey(segment.Sender, segment.SendTime)
+	s := r.shard(key)
+	status, results := r.addToShard(s, key, segment, now)
+	if status == SegmentAdded {
+		evicted, err := r.enforceLimits(key, segment.Sender)
+		results = append(results, evicted...)
+		if err != nil {
+			r.withdraw(s, key, segment)
+			return SegmentRejected, results
+		}
+	}
+	return status, results
+}
+
+// withdraw удаляет принятый сегмент, лимиты для которого не удалось соблюсти.
+// Сообщение без сегментов удаляется целиком.
+func (r *Reassembler) withdraw(s *reassemblyShard, key string, segment Segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.messages[key]
+	if !ok {
+		return
+	}
+	if _, received := state.Segments[segment.SegmentNumber]; !received {
+		return
+	}
+	delete(state.Segments, segment.SegmentNumber)
+	state.PayloadBytes -= len(segment.SegmentPayload)
+	r.bytes.Add(-int64(len(segment.SegmentPayload)))
+	if len(state.Segments) == 0 {
+		delete(s.messages, key)
+		s.deadlines.remove(key)
+		r.release(key, state)
+	}
+}
+
+func (r *Reassembler) addToShard(s *reassemblyShard, key string, segment Segment, now time.Time) (AddStatus, []ReassemblyResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, exists := s.messages[key]
+	if !exists {
+		state = &MessageReassemblyState{
+			Segments:                make(map[int]Segment),
+			TotalSegmentsExpected:   segment.TotalSegments,
+			FirstSegmentArrivalTime: now,
+			Sender:                  segment.Sender,
+			SendTime:                segment.SendTime,
+			Recipient:               segment.Recipient,
+		}
+		s.messages[key] = state
+		r.admit(key, state)
+	} else if state.TotalSegmentsExpected != segment.TotalSegments || state.Sender != segment.Sender || !state.SendTime.Equal(segment.SendTime) {
+		return SegmentMismatch, nil
+	}
+
+	if _, received := state.Segments[segment.SegmentNumber]; received {
+		return SegmentDuplicate, nil
+	}
+
+	size := len(segment.SegmentPayload)
+	if r.limits.MaxMessageBytes > 0 && state.PayloadBytes+size > r.limits.MaxMessageBytes {
+		delete(s.messages, key)
+		s.deadlines.remove(key)
+		r.release(key, state)
+		return SegmentRejected, []ReassemblyResult{evictedResult(key, state, ErrMessageTooLarge)}
+	}
+
+	state.Segments[segment.SegmentNumber] = segment
+	state.LastSegmentArrivalTime = now
+	state.PayloadBytes += size
+	r.bytes.Add(int64(size))
+	r.touch(key)
+
+	if len(state.Segments) < state.TotalSegmentsExpected {
+		s.deadlines.set(key, now.Add(r.timeout))
+		return SegmentAdded, nil
+	}
+
+	delete(s.messages, key)
+	s.deadlines.remove(key)
+	r.release(key, state)
+	return SegmentCompleted, []ReassemblyResult{{
+		Key:    key,
+		Status: historyCompleted,
+		State:  state,
+		Output: formatOutputMessage(state, true),
+	}}
+}
+
+// Check проверяет лимиты сообщения и отправителя, не изменяя состояние.
+// Используется для отклонения сегментов еще до записи в Kafka. Общие лимиты (количество
+// собираемых сообщений, объем буфера) здесь не проверяются: при их превышении Add вытесняет
+// наименее активные сообщения, и поток одного отправителя не блокирует продолжение чужих сообщений.
+func (r *Reassembler) Check(segment Segment) error {
+	if r.limits.MaxSegmentsPerMessage > 0 && segment.TotalSegments > r.limits.MaxSegmentsPerMessage {
+		return ErrTooManySegments
+	}
+
+	key := messageKey(segment.Sender, segment.SendTime)
+	s := r.shard(key)
+	s.mu.Lock()
+	state, exists := s.messages[key]
+	buffered := 0
+	if exists {
+		buffered = state.PayloadBytes
+	}
+	s.mu.Unlock()
+
+	size := buffered + len(segment.SegmentPayload)
+	if r.limits.MaxMessageBytes > 0 && size > r.limits.MaxMessageBytes {
+		return ErrMessageTooLarge
+	}
+	// Сообщение, которое не помещается в буфер даже одно, не будет собрано никогда
+	if r.limits.MaxBufferedBytes > 0 && int64(size) > r.limits.MaxBufferedBytes {
+		return ErrMessageTooLarge
+	}
+	if !exists && r.limits.MaxInFlightPerSender > 0 && r.senderCount(segment.Sender) >= r.limits.MaxInFlightPerSender {
+		return ErrSenderInFlight
+	}
+	return nil
+}
+
+// enforceLimits вытесняет наименее активные сообщения, пока лимиты превышены.
+// Сообщение keep (только что обновленное) не вытесняется; если вытеснить больше нечего,
+// возвращается причина превышения лимита.
+func (r *Reassembler) enforceLimits(keep, sender string) ([]ReassemblyResult, error) {
+	var evicted []ReassemblyResult
+	for {
+		var reason error
+		filter := ""
+		switch {
+		case r.limits.MaxInFlightPerSender > 0 && r.senderCount(sender) > r.limits.MaxInFlightPerSender:
+			reason, filter = ErrSenderInFlight, sender
+		case r.limits.MaxInFlightMessages > 0 && r.messages.Load() > int64(r.limits.MaxInFlightMessages):
+			reason = ErrTooManyInFlight
+		case r.limits.MaxBufferedBytes > 0 && r.bytes.Load() > r.limits.MaxBufferedBytes:
+			reason = ErrBufferFull
+		default:
+			return evicted, nil
+		}
+
+		victim, ok := r.leastActive(filter, keep)
+		if !ok {
+			return evicted, reason
+		}
+		if state, ok := r.Take(victim); ok {
+			evicted = append(evicted, evictedResult(victim, state, reason))
+		}
+	}
+}
+
+// leastActive возвращает сообщение, дольше всего не получавшее сегментов (опционально - только
+// отправителя sender), за O(1): очереди упорядочены по последнему сегменту
+func (r *Reassembler) leastActive(sender, exclude string) (string, bool) {
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+	queue := r.activity
+	if sender != "" {
+		if queue = r.bySender[sender]; queue == nil {
+			return "", false
+		}
+	}
+	for e := queue.Front(); e != nil; e = e.Next() {
+		if key := e.Value.(*activityEntry).key; key != exclude {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// admit учитывает новое сообщение в счетчиках лимитов и очередях вытеснения. Вызывается под мьютексом шарда.
+func (r *Reassembler) admit(key string, state *MessageReassemblyState) {
+	r.messages.Add(1)
+	r.bytes.Add(int64(state.PayloadBytes))
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+	queue := r.bySender[state.Sender]
+	if queue == nil {
+		queue = list.New()
+		r.bySender[state.Sender] = queue
+	}
+	entry := &activityEntry{key: key, sender: state.Sender}
+	entry.global = r.activity.PushBack(entry)
+	entry.bySender = queue.PushBack(entry)
+	r.entries[key] = entry
+}
+
+// touch переносит сообщение в конец очередей вытеснения после получения сегмента
+func (r *Reassembler) touch(key string) {
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+	if entry, ok := r.entries[key]; ok {
+		r.activity.MoveToBack(entry.global)
+		r.bySender[entry.sender].MoveToBack(entry.bySender)
+	}
+}
+
+// release исключает удаленное сообщение из счетчиков лимитов и очередей вытеснения
+func (r *Reassembler) release(key string, state *MessageReassemblyState) {
+	r.messages.Add(-1)
+	r.bytes.Add(-int64(state.PayloadBytes))
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+	entry, ok := r.entries[key]
+	if !ok {
+		return
+	}
+	delete(r.entries, key)
+	r.activity.Remove(entry.global)
+	queue := r.bySender[entry.sender]
+	if queue.Remove(entry.bySender); queue.Len() == 0 {
+		delete(r.bySender, entry.sender)
+	}
+}
+
+func (r *Reassembler) senderCount(sender string) int {
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+	if queue := r.bySender[sender]; queue != nil {
+		return queue.Len()
+	}
+	return 0
+}
+
+// evictedResult формирует уведомление об ошибке для вытесненного сообщения
+func evictedResult(key string, state *MessageReassemblyState, reason error) ReassemblyResult {
+	output := formatOutputMessage(state, false)
+	output.ErrorMsg = fmt.Sprintf("Сообщение вытеснено: %v. Ожидалось %d сегментов, получено %d.", reason, state.TotalSegmentsExpected, len(state.Segments))
+	return ReassemblyResult{
+		Key:    key,
+		Status: historyEvicted,
+		State:  state,
+		Output: output,
+	}
+}
+
+// Expire удаляет сообщения, срок ожидания которых истек к моменту now, и возвращает их как ошибки
+func (r *Reassembler) Expire(now time.Time) []ReassemblyResult {
+	var results []ReassemblyResult
+	for _, s := range r.shards {
+		s.mu.Lock()
+		for _, key := range s.deadlines.popExpired(now) {
+			state, ok := s.messages[key]
+			if !ok {
+				continue
+			}
+			delete(s.messages, key)
+			r.release(key, state)
+			results = append(results, ReassemblyResult{
+				Key:    key,
+				Status: historyTimeout,
+				State:  state,
+				Output: formatOutputMessage(state, false),
+			})
+		}
+		s.mu.Unlock()
+	}
+	return results
+}
+
+// NextDeadline возвращает ближайший срок таймаута среди всех шардов
+func (r *Reassembler) NextDeadline() (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	for _, s := range r.shards {
+		s.mu.Lock()
+		deadline, ok := s.deadlines.next()
+		s.mu.Unlock()
+		if ok && (!found || deadline.Before(earliest)) {
+			earliest, found = deadline, true
+		}
+	}
+	return earliest, found
+}
+
+// Len возвращает количество незавершенных сообщений
+func (r *Reassembler) Len() int {
+	return int(r.messages.Load())
+}
+
+// BufferedBytes возвращает суммарный размер буферизованной полезной нагрузки
+func (r *Reassembler) BufferedBytes() int64 {
+	return r.bytes.Load()
+}
+
+// Describe возвращает описание незавершенного сообщения по ключу
+func (r *Reassembler) Describe(key string, now time.Time) (InFlightInfo, bool) {
+	s := r.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.messages[key]
+	if !ok {
+		return InFlightInfo{}, false
+	}
+	return describeInFlight(key, state, now), true
+}
+
+// Snapshot возвращает описания всех незавершенных сообщений в порядке поступления первого сегмента
+func (r *Reassembler) Snapshot(now time.Time) []InFlightInfo {
+	result := make([]InFlightInfo, 0)
+	for _, s := range r.shards {
+		s.mu.Lock()
+		for key, state := range s.messages {
+			result = append(result, describeInFlight(key, state, now))
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].FirstArrival.Before(result[j].FirstArrival)
+	})
+	return result
+}
+
+// Take извлекает незавершенное сообщение из движка
+func (r *Reassembler) Take(key string) (*MessageReassemblyState, bool) {
+	s := r.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.messages[key]
+	if ok {
+		delete(s.messages, key)
+		s.deadlines.remove(key)
+		r.release(key, state)
+	}
+	return state, ok
+}
+
+// Drain извлекает все незавершенные сообщения из движка
+func (r *Reassembler) Drain() map[string]*MessageReassemblyState {
+	states := make(map[string]*MessageReassemblyState)
+	for _, s := range r.shards {
+		s.mu.Lock()
+		for key, state := range s.messages {
+			states[key] = state
+			r.release(key, state)
+		}
+		s.messages = make(map[string]*MessageReassemblyState)
+		s.deadlines = newDeadlineQueue()
+		s.mu.Unlock()
+	}
+	return states
+}
+
+// Restore добавляет ранее сохраненные сообщения; срок ожидания отсчитывается от now.
+// Лимиты применяются после восстановления, вытесненные сообщения возвращаются.
+func (r *Reassembler) Restore(states map[string]*MessageReassemblyState, now time.Time) []ReassemblyResult {
+	for key, state := range states {
+		state.LastSegmentArrivalTime = now
+		state.PayloadBytes = 0
+		for _, segment := range state.Segments {
+			state.PayloadBytes += len(segment.SegmentPayload)
+		}
+		s := r.shard(key)
+		s.mu.Lock()
+		if _, exists := s.messages[key]; !exists {
+			s.messages[key] = state
+			s.deadlines.set(key, now.Add(r.timeout))
+			r.admit(key, state)
+		}
+		s.mu.Unlock()
+	}
+	evicted, _ := r.enforceLimits("", "")
+	return evicted
+}
+
+// describeInFlight формирует описание незавершенного сообщения. Вызывается под мьютексом шарда.
+func describeInFlight(key string, state *MessageReassemblyState, now time.Time) InFlightInfo {
+	info := InFlightInfo{
+		Key:              key,
+		Sender:           state.Sender,
+		SendTime:         state.SendTime,
+		TotalSegments:    state.TotalSegmentsExpected,
+		PayloadBytes:     state.PayloadBytes,
+		ReceivedSegments: make([]int, 0, len(state.Segments)),
+		MissingSegments:  make([]int, 0),
+		FirstArrival:     state.FirstSegmentArrivalTime,
+		LastArrival:      state.LastSegmentArrivalTime,
+		Age:              now.Sub(state.FirstSegmentArrivalTime).String(),
+		Idle:             now.Sub(state.LastSegmentArrivalTime).String(),
+	}
+	for i := 1; i <= state.TotalSegmentsExpected; i++ {
+		if _, ok := state.Segments[i]; ok {
+			info.ReceivedSegments = append(info.ReceivedSegments, i)
+		} else {
+			info.MissingSegments = append(info.MissingSegments, i)
+		}
+	}
+	return info
+}
+
+// formatOutputMessage - Вспомогательная функция для форматирования финального сообщения OutputMessage
+func formatOutputMessage(state *MessageReassemblyState, success bool) OutputMessage {
+	output := OutputMessage{
+		Sender:    state.Sender,
+		SendTime:  state.SendTime,
+		Recipient: state.Recipient,
+	}
+
+	if success {
+		// Собираем полезную нагрузку из сегментов
+		payloadBuilder := strings.Builder{}
+		for i := 1; i <= state.TotalSegmentsExpected; i++ {
+			segment, ok := state.Segments[i]
+			if ok {
+				payloadBuilder.WriteString(segment.SegmentPayload)
+			} else {
+				// Это случай ошибки сборки, хотя мы форматируем как "успех"
+				// В реальном приложении, возможно, стоило бы пометить это как ошибку или логировать
+				log.Printf("Внимание: Отсутствует сегмент %d для сообщения '%s' при сборке успешной полезной нагрузки.", i, state.Sender)
+			}
+		}
+		output.Payload = payloadBuilder.String()
+		output.Error = false
+	} else {
+		// Сообщение об ошибке
+		output.Error = true
+		output.ErrorMsg = fmt.Sprintf("Истек таймаут сообщения. Ожидалось %d сегментов, получено %d.", state.TotalSegmentsExpected, len(state.Segments))
+		output.Payload = "" // Полезная нагрузка отсутствует при ошибке
+	}
+
+	return output
+}
+
+// formatPartialMessage собирает сообщение из имеющихся сегментов при принудительном завершении
+func formatPartialMessage(state *MessageReassemblyState) OutputMessage {
+	if len(state.Segments) == state.TotalSegmentsExpected {
+		return formatOutputMessage(state, true)
+	}
+
+	payloadBuilder := strings.Builder{}
+	for i := 1; i <= state.TotalSegmentsExpected; i++ {
+		if segment, ok := state.Segments[i]; ok {
+			payloadBuilder.WriteString(segment.SegmentPayload)
+		}
+	}
+
+	return OutputMessage{
+		Sender:    state.Sender,
+		SendTime:  state.SendTime,
+		Recipient: state.Recipient,
+		Payload:   payloadBuilder.String(),
+		Error:     true,
+		ErrorMsg:  fmt.Sprintf("Сообщение принудительно завершено. Ожидалось %d сегментов, получено %d.", state.TotalSegmentsExpected, len(state.Segments)),
+	}
+}
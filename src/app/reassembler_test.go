@@ -0,0 +1,320 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+// testSegment формирует сегмент сообщения отправителя sender
+func testSegment(sender string, number, total int, payload string) Segment {
+	return Segment{
+		SegmentNumber:  number,
+		TotalSegments:  total,
+		Sender:         sender,
+		SendTime:       time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC),
+		SegmentPayload: payload,
+	}
+}
+
+func TestReassemblerAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []Segment
+		want     []AddStatus
+		inFlight int
+		payload  string // Собранное сообщение, если последний сегмент его завершает
+	}{
+		{
+			name:     "сборка не по порядку",
+			segments: []Segment{testSegment("a", 2, 3, "b"), testSegment("a", 3, 3, "c"), testSegment("a", 1, 3, "a")},
+			want:     []AddStatus{SegmentAdded, SegmentAdded, SegmentCompleted},
+			payload:  "abc",
+		},
+		{
+			name:     "дубликат",
+			segments: []Segment{testSegment("a", 1, 2, "a"), testSegment("a", 1, 2, "a")},
+			want:     []AddStatus{SegmentAdded, SegmentDuplicate},
+			inFlight: 1,
+		},
+		{
+			name:     "номер вне диапазона",
+			segments: []Segment{testSegment("a", 0, 2, "a"), testSegment("a", 3, 2, "a"), testSegment("a", -1, 2, "a")},
+			want:     []AddStatus{SegmentInvalid, SegmentInvalid, SegmentInvalid},
+		},
+		{
+			name:     "несогласованное TotalSegments",
+			segments: []Segment{testSegment("a", 1, 3, "a"), testSegment("a", 2, 2, "b")},
+			want:     []AddStatus{SegmentAdded, SegmentMismatch},
+			inFlight: 1,
+		},
+		{
+			name:     "односегментное сообщение",
+			segments: []Segment{testSegment("a", 1, 1, "целиком")},
+			want:     []AddStatus{SegmentCompleted},
+			payload:  "целиком",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(4, time.Minute, ReassemblyLimits{})
+			now := time.Now()
+			var last []ReassemblyResult
+			for i, segment := range tt.segments {
+				status, results := r.Add(segment, now)
+				if status != tt.want[i] {
+					t.Fatalf("сегмент %d: статус %d, ожидался %d", i+1, status, tt.want[i])
+				}
+				last = results
+			}
+			if r.Len() != tt.inFlight {
+				t.Errorf("незавершенных сообщений %d, ожидалось %d", r.Len(), tt.inFlight)
+			}
+			if tt.payload != "" {
+				if len(last) != 1 || last[0].Status != historyCompleted || last[0].Output.Payload != tt.payload {
+					t.Errorf("результат сборки %+v, ожидалось %q", last, tt.payload)
+				}
+				if r.BufferedBytes() != 0 {
+					t.Errorf("после сборки буферизовано %d байт", r.BufferedBytes())
+				}
+			}
+		})
+	}
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	r := NewReassembler(4, time.Minute, ReassemblyLimits{})
+	start := time.Now()
+	if _, ok := r.NextDeadline(); ok {
+		t.Fatal("срок у пустого движка")
+	}
+
+	r.Add(testSegment("a", 1, 2, "a"), start)
+	r.Add(testSegment("b", 1, 2, "b"), start.Add(10*time.Second))
+	if deadline, ok := r.NextDeadline(); !ok || !deadline.Equal(start.Add(time.Minute)) {
+		t.Fatalf("ближайший срок %v, ожидался %v", deadline, start.Add(time.Minute))
+	}
+
+	// Несогласованный сегмент срок не переносит
+	r.Add(testSegment("a", 1, 3, "x"), start.Add(20*time.Second))
+	tests := []struct {
+		at   time.Duration
+		want []string // Отправители сообщений с истекшим сроком
+	}{
+		{at: 59 * time.Second},
+		{at: time.Minute, want: []string{"a"}},
+		{at: time.Minute, want: nil},
+		{at: 70 * time.Second, want: []string{"b"}},
+	}
+	for _, tt := range tests {
+		results := r.Expire(start.Add(tt.at))
+		if len(results) != len(tt.want) {
+			t.Fatalf("к %s истекло %d сообщений, ожидалось %v", tt.at, len(results), tt.want)
+		}
+		for i, result := range results {
+			if result.State.Sender != tt.want[i] || result.Status != historyTimeout || !result.Output.Error {
+				t.Errorf("к %s: результат %+v", tt.at, result)
+			}
+		}
+	}
+	if _, ok := r.NextDeadline(); ok || r.Len() != 0 || r.BufferedBytes() != 0 {
+		t.Errorf("после истечения сроков: %d сообщений, %d байт", r.Len(), r.BufferedBytes())
+	}
+}
+
+func TestReassemblerSnapshotTakeDrain(t *testing.T) {
+	r := NewReassembler(4, time.Minute, ReassemblyLimits{})
+	start := time.Now()
+	for i, sender := range []string{"c", "a", "b"} {
+		r.Add(testSegment(sender, 1, 3, "xx"), start.Add(time.Duration(i)*time.Second))
+	}
+	r.Add(testSegment("a", 3, 3, "yy"), start.Add(5*time.Second))
+
+	snapshot := r.Snapshot(start.Add(10 * time.Second))
+	if len(snapshot) != 3 || snapshot[0].Sender != "c" || snapshot[1].Sender != "a" || snapshot[2].Sender != "b" {
+		t.Fatalf("снимок не упорядочен по первому сегменту: %+v", snapshot)
+	}
+	if info := snapshot[1]; len(info.ReceivedSegments) != 2 || len(info.MissingSegments) != 1 || info.MissingSegments[0] != 2 || info.PayloadBytes != 4 {
+		t.Errorf("описание сообщения a: %+v", info)
+	}
+
+	key := messageKey("a", testSegment("a", 1, 3, "").SendTime)
+	if _, ok := r.Describe(key, start); !ok {
+		t.Fatal("сообщение a не найдено")
+	}
+	state, ok := r.Take(key)
+	if !ok || len(state.Segments) != 2 {
+		t.Fatalf("извлечено %+v", state)
+	}
+	if _, ok := r.Take(key); ok {
+		t.Error("сообщение извлечено повторно")
+	}
+	if r.Len() != 2 || r.BufferedBytes() != 4 {
+		t.Errorf("после Take: %d сообщений, %d байт", r.Len(), r.BufferedBytes())
+	}
+
+	states := r.Drain()
+	if len(states) != 2 || r.Len() != 0 || r.BufferedBytes() != 0 {
+		t.Errorf("Drain: извлечено %d, осталось %d сообщений и %d байт", len(states), r.Len(), r.BufferedBytes())
+	}
+	if _, ok := r.NextDeadline(); ok {
+		t.Error("после Drain остались сроки")
+	}
+}
+
+func TestReassemblerRestoreRoundTrip(t *testing.T) {
+	source := NewReassembler(4, time.Minute, ReassemblyLimits{})
+	start := time.Now()
+	source.Add(testSegment("a", 1, 2, "при"), start)
+	source.Add(testSegment("b", 2, 3, "вет"), start)
+	states := source.Drain()
+
+	restored := NewReassembler(2, time.Minute, ReassemblyLimits{})
+	later := start.Add(time.Hour)
+	if evicted := restored.Restore(states, later); len(evicted) != 0 {
+		t.Fatalf("вытеснено при восстановлении: %+v", evicted)
+	}
+	if restored.Len() != 2 || restored.BufferedBytes() != int64(len("при")+len("вет")) {
+		t.Fatalf("восстановлено %d сообщений, %d байт", restored.Len(), restored.BufferedBytes())
+	}
+	// Срок ожидания отсчитывается от момента восстановления
+	if deadline, ok := restored.NextDeadline(); !ok || !deadline.Equal(later.Add(time.Minute)) {
+		t.Errorf("срок после восстановления %v", deadline)
+	}
+
+	status, results := restored.Add(testSegment("a", 2, 2, "вет"), later)
+	if status != SegmentCompleted || results[0].Output.Payload != "привет" {
+		t.Errorf("сборка после восстановления: %d %+v", status, results)
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	at := func(seconds int) time.Time { return time.Unix(1700000000, 0).Add(time.Duration(seconds) * time.Second) }
+	segmentAt := func(sender string, sendSecond, number, total int, payload string) Segment {
+		segment := testSegment(sender, number, total, payload)
+		segment.SendTime = segment.SendTime.Add(time.Duration(sendSecond) * time.Second)
+		return segment
+	}
+
+	tests := []struct {
+		name     string
+		limits   ReassemblyLimits
+		segments []Segment
+		status   AddStatus // Статус последнего сегмента
+		evicted  []string  // Отправители вытесненных последним сегментом сообщений
+		inFlight int
+		bytes    int64
+	}{
+		{
+			name:     "вытесняется наименее активное сообщение",
+			limits:   ReassemblyLimits{MaxInFlightMessages: 2},
+			segments: []Segment{segmentAt("a", 0, 1, 2, "a"), segmentAt("b", 0, 1, 2, "b"), segmentAt("a", 0, 1, 3, "x"), segmentAt("c", 0, 1, 2, "c")},
+			status:   SegmentAdded, evicted: []string{"a"}, inFlight: 2, bytes: 2,
+		},
+		{
+			name:     "новый сегмент продлевает активность",
+			limits:   ReassemblyLimits{MaxInFlightMessages: 2},
+			segments: []Segment{segmentAt("a", 0, 1, 3, "a"), segmentAt("b", 0, 1, 2, "b"), segmentAt("a", 0, 2, 3, "a"), segmentAt("c", 0, 1, 2, "c")},
+			status:   SegmentAdded, evicted: []string{"b"}, inFlight: 2, bytes: 3,
+		},
+		{
+			name:     "лимит отправителя вытесняет только его сообщения",
+			limits:   ReassemblyLimits{MaxInFlightPerSender: 1},
+			segments: []Segment{segmentAt("a", 0, 1, 2, "a"), segmentAt("b", 0, 1, 2, "b"), segmentAt("b", 1, 1, 2, "b")},
+			status:   SegmentAdded, evicted: []string{"b"}, inFlight: 2, bytes: 2,
+		},
+		{
+			name:     "объем буфера",
+			limits:   ReassemblyLimits{MaxBufferedBytes: 4},
+			segments: []Segment{segmentAt("a", 0, 1, 2, "aa"), segmentAt("b", 0, 1, 2, "bb"), segmentAt("c", 0, 1, 2, "cc")},
+			status:   SegmentAdded, evicted: []string{"a"}, inFlight: 2, bytes: 4,
+		},
+		{
+			name:     "вытеснить можно только само сообщение",
+			limits:   ReassemblyLimits{MaxBufferedBytes: 4},
+			segments: []Segment{segmentAt("a", 0, 1, 3, "aaa"), segmentAt("a", 0, 2, 3, "aa")},
+			status:   SegmentRejected, inFlight: 1, bytes: 3,
+		},
+		{
+			name:     "первый сегмент больше буфера",
+			limits:   ReassemblyLimits{MaxBufferedBytes: 4},
+			segments: []Segment{segmentAt("a", 0, 1, 2, "aaaaa")},
+			status:   SegmentRejected,
+		},
+		{
+			name:     "размер сообщения",
+			limits:   ReassemblyLimits{MaxMessageBytes: 3},
+			segments: []Segment{segmentAt("a", 0, 1, 2, "aa"), segmentAt("a", 0, 2, 2, "aa")},
+			status:   SegmentRejected, evicted: []string{"a"},
+		},
+		{
+			name:     "количество сегментов",
+			limits:   ReassemblyLimits{MaxSegmentsPerMessage: 2},
+			segments: []Segment{segmentAt("a", 0, 1, 3, "a")},
+			status:   SegmentRejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(4, time.Minute, tt.limits)
+			var status AddStatus
+			var results []ReassemblyResult
+			for i, segment := range tt.segments {
+				status, results = r.Add(segment, at(i))
+			}
+			if status != tt.status {
+				t.Errorf("статус %d, ожидался %d", status, tt.status)
+			}
+			if len(results) != len(tt.evicted) {
+				t.Fatalf("вытеснено %+v, ожидалось %v", results, tt.evicted)
+			}
+			for i, result := range results {
+				if result.State.Sender != tt.evicted[i] || result.Status != historyEvicted || !result.Output.Error {
+					t.Errorf("вытеснено %+v, ожидалось %s", result, tt.evicted[i])
+				}
+			}
+			if r.Len() != tt.inFlight || r.BufferedBytes() != tt.bytes {
+				t.Errorf("осталось %d сообщений и %d байт, ожидалось %d и %d", r.Len(), r.BufferedBytes(), tt.inFlight, tt.bytes)
+			}
+			if len(r.entries) != r.Len() || r.activity.Len() != r.Len() {
+				t.Errorf("очереди вытеснения (%d, %d) расходятся с сообщениями (%d)", len(r.entries), r.activity.Len(), r.Len())
+			}
+		})
+	}
+}
+
+func TestReassemblerCheck(t *testing.T) {
+	r := NewReassembler(4, time.Minute, ReassemblyLimits{MaxInFlightMessages: 2, MaxInFlightPerSender: 1, MaxBufferedBytes: 4, MaxMessageBytes: 4, MaxSegmentsPerMessage: 3})
+	r.Add(testSegment("a", 1, 3, "aaa"), time.Now())
+	r.Add(testSegment("flood", 1, 2, "f"), time.Now()) // Буфер и лимит сообщений заполнены
+
+	tests := []struct {
+		name    string
+		segment Segment
+		want    error
+	}{
+		{"продолжение при заполненном буфере", testSegment("a", 2, 3, "a"), nil},
+		{"новое сообщение при заполненном буфере", testSegment("b", 1, 2, "b"), nil},
+		{"сообщение не поместится в буфер даже одно", testSegment("a", 2, 3, "aa"), ErrMessageTooLarge},
+		{"сегмент больше лимита сообщения", testSegment("c", 1, 1, "ccccc"), ErrMessageTooLarge},
+		{"слишком много сегментов", testSegment("c", 1, 4, "c"), ErrTooManySegments},
+		{"второе сообщение отправителя", withSendTime(testSegment("flood", 1, 2, "f"), time.Minute), ErrSenderInFlight},
+		{"продолжение сообщения отправителя", testSegment("flood", 2, 2, "f"), nil},
+	}
+	for _, tt := range tests {
+		if err := r.Check(tt.segment); err != tt.want {
+			t.Errorf("%s: %v, ожидалось %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Продолжение принятого сообщения собирается, вытесняя наименее активное
+	status, results := r.Add(testSegment("a", 2, 3, "a"), time.Now())
+	if status != SegmentAdded || len(results) != 1 || results[0].Key != messageKey("flood", testSegment("flood", 1, 1, "").SendTime) {
+		t.Errorf("продолжение при заполненном буфере: %d %+v", status, results)
+	}
+}
+
+// withSendTime сдвигает время отправки сегмента на d
+func withSendTime(segment Segment, d time.Duration) Segment {
+	segment.SendTime = segment.SendTime.Add(d)
+	return segment
+}
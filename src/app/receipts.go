@@ -0,0 +1,276 @@
+package app
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Типы сегментов
+const (
+	segmentData    = ""        // Часть сообщения
+	segmentReceipt = "receipt" // Квитанция о доставке, возвращаемая отправителю через канальный уровень
+)
+
+// Статусы доставки сообщения на стороне отправителя
+const (
+	deliveryPending    = "pending"     // Сегменты передаются канальному уровню
+	deliverySent       = "sent"        // Сегменты переданы канальному уровню, квитанции еще нет
+	deliverySendFailed = "send_failed" // Не удалось передать сегменты канальному уровню
+	deliveryDelivered  = "delivered"   // Получатель собрал сообщение
+	deliveryFailed     = "failed"      // Получатель не собрал сообщение (таймаут, вытеснение и т.д.)
+)
+
+// Причины, по которым квитанция не применяется к статусу сообщения
+var (
+	errReceiptUnknown = errors.New("сообщение не отправлялось этим узлом")
+	errReceiptFinal   = errors.New("окончательный статус уже получен")
+)
+
+// Receipt - Квитанция о результате сборки, передаваемая в полезной нагрузке сегмента типа receipt
+type Receipt struct {
+	MessageID  string    `json:"message_id"`
+	Status     string    `json:"status"` // delivered или failed
+	ErrorMsg   string    `json:"error_msg,omitempty"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// MessageStatus - Статус доставки отправленного сообщения
+type MessageStatus struct {
+	MessageID     string    `json:"message_id"`
+	Sender        string    `json:"sender"`
+	SendTime      time.Time `json:"send_time"`
+	Recipient     string    `json:"recipient,omitempty"`
+	Status        string    `json:"status"`
+	TotalSegments int       `json:"total_segments,omitempty"`
+	ErrorMsg      string    `json:"error_msg,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// final сообщает, получен ли окончательный результат доставки
+func (s *MessageStatus) final() bool {
+	return s.Status == deliveryDelivered || s.Status == deliveryFailed
+}
+
+// statusStore - Статусы недавно отправленных сообщений, ограниченные по количеству и времени хранения
+type statusStore struct {
+	retention time.Duration
+	size      int
+
+	mu    sync.Mutex
+	items map[string]*MessageStatus
+	order *list.List // Идентификаторы в порядке создания
+}
+
+func newStatusStore(retention time.Duration, size int) *statusStore {
+	return &statusStore{retention: retention, size: max(size, 1), items: make(map[string]*MessageStatus), order: list.New()}
+}
+
+// Статусы доставки сообщений, отправленных через /send
+var messageStatuses = newStatusStore(ReceiptRetention, ReceiptMaxMessages)
+
+// update изменяет статус сообщения (создавая его при отсутствии) и возвращает копию
+func (s *statusStore) update(id string, now time.Time, fn func(status *MessageStatus)) MessageStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(now)
+
+	status, ok := s.items[id]
+	if !ok {
+		status = &MessageStatus{MessageID: id}
+		s.items[id] = status
+		s.order.PushBack(id)
+		if s.order.Len() > s.size {
+			delete(s.items, s.order.Remove(s.order.Front()).(string))
+		}
+	}
+	fn(status)
+	status.UpdatedAt = now
+	return *status
+}
+
+// applyReceipt применяет квитанцию к статусу сообщения, отправленного этим узлом, и возвращает копию.
+// Квитанции для неизвестных сообщений не создают статус, окончательный статус не перезаписывается.
+func (s *statusStore) applyReceipt(receipt Receipt, now time.Time) (MessageStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(now)
+
+	status, ok := s.items[receipt.MessageID]
+	if !ok {
+		return MessageStatus{}, errReceiptUnknown
+	}
+	if status.final() {
+		return *status, errReceiptFinal
+	}
+	status.Status, status.ErrorMsg = receipt.Status, receipt.ErrorMsg
+	status.UpdatedAt = now
+	return *status, nil
+}
+
+// get возвращает копию статуса сообщения
+func (s *statusStore) get(id string, now time.Time) (MessageStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(now)
+	status, ok := s.items[id]
+	if !ok {
+		return MessageStatus{}, false
+	}
+	return *status, true
+}
+
+// pruneLocked удаляет статусы, не обновлявшиеся дольше retention. Вызывается под s.mu.
+func (s *statusStore) pruneLocked(now time.Time) {
+	for elem := s.order.Front(); elem != nil; {
+		next := elem.Next()
+		id := elem.Value.(string)
+		if status, ok := s.items[id]; !ok || now.Sub(status.UpdatedAt) > s.retention {
+			delete(s.items, id)
+			s.order.Remove(elem)
+		} else {
+			break // Статусы упорядочены по созданию; более новые обычно обновлялись позже
+		}
+		elem = next
+	}
+}
+
+// recordSendStatus сохраняет результат передачи сегментов канальному уровню, не затирая
+// уже полученную квитанцию (она может прийти раньше, чем HandleSend дождется ответов)
+func recordSendStatus(message SendRequest, totalSegments int, status string, errorMsg string) {
+	id := messageKey(message.Sender, message.SendTime)
+	messageStatuses.update(id, time.Now(), func(s *MessageStatus) {
+		s.Sender, s.SendTime, s.Recipient, s.TotalSegments = message.Sender, message.SendTime, message.Recipient, totalSegments
+		if !s.final() {
+			s.Status, s.ErrorMsg = status, errorMsg
+		}
+	})
+}
+
+// receiptSink отправляет квитанцию о собранном или несобранном сообщении обратно отправителю
+type receiptSink struct{}
+
+func (receiptSink) Name() string { return "receipt" }
+
+func (receiptSink) Deliver(ctx context.Context, message OutputMessage) error {
+	receipt := Receipt{
+		MessageID:  messageKey(message.Sender, message.SendTime),
+		Status:     deliveryDelivered,
+		FinishedAt: time.Now().UTC(),
+	}
+	if message.Error {
+		receipt.Status, receipt.ErrorMsg = deliveryFailed, message.ErrorMsg
+	}
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	err = sendSegmentOnce(ctx, Segment{
+		SegmentNumber:  1,
+		TotalSegments:  1,
+		Sender:         message.Sender,
+		SendTime:       message.SendTime,
+		SegmentPayload: string(payload),
+		Recipient:      message.Recipient,
+		Type:           segmentReceipt,
+	})
+	// 404 - узел отправителя не знает сообщение (handleReceipt, errReceiptUnknown): повтор не поможет
+	var statusErr *channelStatusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound {
+		return fmt.Errorf("%w: квитанция для '%s' отклонена: %v", errSinkUnavailable, receipt.MessageID, err)
+	}
+	return err
+}
+
+// handleReceipt принимает квитанцию от канального уровня и обновляет статус сообщения.
+// Квитанции принимаются только для сообщений, отправленных этим узлом через /send.
+func handleReceipt(w http.ResponseWriter, segment Segment) {
+	var receipt Receipt
+	if err := json.Unmarshal([]byte(segment.SegmentPayload), &receipt); err != nil || receipt.MessageID == "" ||
+		(receipt.Status != deliveryDelivered && receipt.Status != deliveryFailed) {
+		http.Error(w, "Некорректная квитанция", http.StatusBadRequest)
+		log.Printf("Некорректная квитанция от канального уровня: %v", err)
+		return
+	}
+	// Квитанция возвращается с отправителем и временем исходного сообщения
+	if receipt.MessageID != messageKey(segment.Sender, segment.SendTime) {
+		http.Error(w, "Квитанция не соответствует отправителю сегмента", http.StatusBadRequest)
+		log.Printf("Квитанция для '%s' получена в сегменте от '%s'", receipt.MessageID, segment.Sender)
+		return
+	}
+
+	status, err := messageStatuses.applyReceipt(receipt, time.Now())
+	switch {
+	case errors.Is(err, errReceiptUnknown):
+		http.Error(w, fmt.Sprintf("Квитанция отклонена: %v", err), http.StatusNotFound)
+		log.Printf("Отклонена квитанция для неизвестного сообщения '%s'", receipt.MessageID)
+		return
+	case errors.Is(err, errReceiptFinal):
+		// Повтор квитанции канальным уровнем: отвечаем 200, чтобы повторы прекратились
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Квитанция уже учтена")
+		log.Printf("Отброшена квитанция для '%s' (%s): статус уже %s", receipt.MessageID, receipt.Status, status.Status)
+		return
+	}
+	log.Printf("Получена квитанция для сообщения '%s': %s %s", receipt.MessageID, receipt.Status, receipt.ErrorMsg)
+
+	if ReceiptCallbackURL != "" {
+		callback := configuredSink{sink: receiptCallbackSink{status: status}, retry: sinkRetry}
+		trackDelivery("квитанция "+receipt.MessageID, func() {
+			deliverToSink(callback, OutputMessage{Sender: status.Sender, SendTime: status.SendTime, Recipient: status.Recipient})
+		})
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Квитанция принята")
+}
+
+// receiptCallbackSink сообщает прикладному уровню (ReceiptCallbackURL) статус доставки отправленного сообщения.
+// Доставляется через deliverToSink с повторами sinkRetry; доставляемое сообщение не используется.
+type receiptCallbackSink struct {
+	status MessageStatus
+}
+
+func (receiptCallbackSink) Name() string { return "receipt_callback" }
+
+func (s receiptCallbackSink) Deliver(ctx context.Context, _ OutputMessage) error {
+	body, err := json.Marshal(s.status)
+	if err != nil {
+		return fmt.Errorf("ошибка при маршалинге статуса доставки: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ReceiptCallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := outboundHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("некорректный статус ответа от %s: %d", ReceiptCallbackURL, resp.StatusCode)
+	}
+	return nil
+}
+
+// HandleMessageStatus - GET /messages/{id}/status: статус доставки отправленного сообщения.
+// Идентификатор передается экранированным (url.PathEscape): ключ отправителя с "/" иначе не совпадет с маршрутом.
+func HandleMessageStatus(w http.ResponseWriter, r *http.Request) {
+	id := pathVar(r, "id")
+	status, ok := messageStatuses.get(id, time.Now())
+	if !ok {
+		http.Error(w, "Статус сообщения не найден", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
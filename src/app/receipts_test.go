@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// withReceipts задает хранилище статусов и адрес уведомлений о квитанциях на время теста
+func withReceipts(t *testing.T, callbackURL string) {
+	t.Helper()
+	savedStatuses, savedCallback, savedRetry := messageStatuses, ReceiptCallbackURL, sinkRetry
+	t.Cleanup(func() { messageStatuses, ReceiptCallbackURL, sinkRetry = savedStatuses, savedCallback, savedRetry })
+	messageStatuses = newStatusStore(time.Hour, 100)
+	ReceiptCallbackURL = callbackURL
+	sinkRetry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+// receiptSegment формирует сегмент квитанции для сообщения message
+func receiptSegment(t *testing.T, message SendRequest, status, messageID string) Segment {
+	t.Helper()
+	if messageID == "" {
+		messageID = messageKey(message.Sender, message.SendTime)
+	}
+	payload, err := json.Marshal(Receipt{MessageID: messageID, Status: status, FinishedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Segment{SegmentNumber: 1, TotalSegments: 1, Sender: message.Sender, SendTime: message.SendTime, SegmentPayload: string(payload), Type: segmentReceipt}
+}
+
+func TestHandleReceipt(t *testing.T) {
+	withReceipts(t, "")
+	sent := SendRequest{Sender: "alice", SendTime: time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)}
+	unknown := SendRequest{Sender: "mallory", SendTime: sent.SendTime}
+	recordSendStatus(sent, 2, deliverySent, "")
+	id := messageKey(sent.Sender, sent.SendTime)
+
+	tests := []struct {
+		name    string
+		segment Segment
+		code    int
+		status  string // Статус сообщения alice после квитанции
+	}{
+		{name: "некорректная квитанция", segment: Segment{Sender: sent.Sender, SendTime: sent.SendTime, SegmentPayload: "{"}, code: http.StatusBadRequest, status: deliverySent},
+		{name: "неизвестный статус", segment: receiptSegment(t, sent, "read", ""), code: http.StatusBadRequest, status: deliverySent},
+		{name: "чужой идентификатор", segment: receiptSegment(t, unknown, deliveryDelivered, id), code: http.StatusBadRequest, status: deliverySent},
+		{name: "неизвестное сообщение", segment: receiptSegment(t, unknown, deliveryDelivered, ""), code: http.StatusNotFound, status: deliverySent},
+		{name: "доставлено", segment: receiptSegment(t, sent, deliveryDelivered, ""), code: http.StatusOK, status: deliveryDelivered},
+		{name: "понижение статуса", segment: receiptSegment(t, sent, deliveryFailed, ""), code: http.StatusOK, status: deliveryDelivered},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleReceipt(w, tt.segment)
+		if w.Code != tt.code {
+			t.Errorf("%s: ответ %d, ожидался %d: %s", tt.name, w.Code, tt.code, w.Body)
+		}
+		if status, _ := messageStatuses.get(id, time.Now()); status.Status != tt.status {
+			t.Errorf("%s: статус %q, ожидался %q", tt.name, status.Status, tt.status)
+		}
+	}
+	if _, ok := messageStatuses.get(messageKey(unknown.Sender, unknown.SendTime), time.Now()); ok {
+		t.Error("квитанция создала статус неизвестного сообщения")
+	}
+
+	// Результат передачи сегментов не затирает полученную раньше квитанцию
+	recordSendStatus(sent, 2, deliverySent, "")
+	if status, _ := messageStatuses.get(id, time.Now()); status.Status != deliveryDelivered {
+		t.Errorf("квитанция затерта статусом %q", status.Status)
+	}
+}
+
+func TestReceiptCallbackRetries(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan MessageStatus, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "временно недоступен", http.StatusServiceUnavailable)
+			return
+		}
+		var status MessageStatus
+		json.NewDecoder(r.Body).Decode(&status)
+		received <- status
+	}))
+	defer server.Close()
+	withReceipts(t, server.URL)
+
+	sent := SendRequest{Sender: "bob", SendTime: time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC), Recipient: "carol"}
+	recordSendStatus(sent, 1, deliverySent, "")
+	w := httptest.NewRecorder()
+	handleReceipt(w, receiptSegment(t, sent, deliveryFailed, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("квитанция не принята: %d %s", w.Code, w.Body)
+	}
+	waitDeliveries(5 * time.Second)
+
+	select {
+	case status := <-received:
+		if status.Status != deliveryFailed || status.Sender != "bob" || status.Recipient != "carol" || status.TotalSegments != 1 {
+			t.Errorf("уведомление: %+v", status)
+		}
+	default:
+		t.Fatal("уведомление о квитанции не доставлено после повтора")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("попыток уведомления %d, ожидалось 2", n)
+	}
+}
+
+func TestReceiptSinkUnknownMessageNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "Квитанция отклонена: сообщение не найдено", http.StatusNotFound)
+	}))
+	defer channel.Close()
+	withReceipts(t, "")
+	withChannelPool(t, channel.URL, strategyRoundRobin)
+
+	message := OutputMessage{Sender: "mallory", SendTime: time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)}
+	err := receiptSink{}.Deliver(context.Background(), message)
+	if !errors.Is(err, errSinkUnavailable) {
+		t.Fatalf("ответ 404 не считается окончательным: %v", err)
+	}
+
+	deliverToSink(configuredSink{sink: receiptSink{}, retry: sinkRetry}, message)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("запросов канальному уровню %d, ожидалось 2 (без повторов)", n)
+	}
+}
+
+func TestHandleMessageStatusEscapedID(t *testing.T) {
+	withReceipts(t, "")
+	sent := SendRequest{Sender: "team/alice ?#", SendTime: time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)}
+	recordSendStatus(sent, 1, deliverySent, "")
+	id := messageKey(sent.Sender, sent.SendTime)
+
+	router := mux.NewRouter().UseEncodedPath()
+	router.HandleFunc("/messages/{id}/status", HandleMessageStatus).Methods(http.MethodGet)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/messages/" + url.PathEscape(id) + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status MessageStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || status.MessageID != id || status.Status != deliverySent {
+		t.Errorf("экранированный идентификатор: %s %+v", resp.Status, status)
+	}
+
+	// Без экранирования "/" делит идентификатор на сегменты пути, и маршрут не совпадает
+	resp, err = http.Get(server.URL + "/messages/team/alice_x/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("неэкранированный идентификатор: %s", resp.Status)
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// FuzzSplitReassemble проверяет, что разбиение на сегменты и сборка в произвольном порядке
+// восстанавливают исходную полезную нагрузку, а каждый сегмент укладывается в лимит размера.
+func FuzzSplitReassemble(f *testing.F) {
+	f.Add("Hello, world!", SegmentSize, int64(1))
+	f.Add(strings.Repeat("abcdefghij", 50), 7, int64(2))
+	f.Add("Привет, 世界! 🙂", 5, int64(3))
+	f.Add("🙂🙂🙂", 4, int64(4))
+
+	f.Fuzz(func(t *testing.T, payload string, segmentSize int, seed int64) {
+		if payload == "" {
+			return
+		}
+		// Лимит не меньше длины одного символа UTF-8, иначе его нельзя соблюсти
+		segmentSize = utf8.UTFMax + abs(segmentSize)%1024
+
+		parts := splitSegment(payload, segmentSize)
+		if strings.Join(parts, "") != payload {
+			t.Fatalf("склейка сегментов не совпадает с исходной нагрузкой")
+		}
+		for i, part := range parts {
+			if len(part) == 0 || len(part) > segmentSize {
+				t.Fatalf("сегмент %d длиной %d байт нарушает лимит %d", i+1, len(part), segmentSize)
+			}
+			if utf8.ValidString(payload) && !utf8.ValidString(part) {
+				t.Fatalf("сегмент %d разрывает символ UTF-8: %q", i+1, part)
+			}
+		}
+
+		sendTime := time.Unix(0, seed).UTC()
+		segments := make([]Segment, len(parts))
+		for i, part := range parts {
+			segments[i] = Segment{
+				SegmentNumber:  i + 1,
+				TotalSegments:  len(parts),
+				Sender:         "fuzz",
+				SendTime:       sendTime,
+				SegmentPayload: part,
+			}
+		}
+		rand.New(rand.NewSource(seed)).Shuffle(len(segments), func(i, j int) {
+			segments[i], segments[j] = segments[j], segments[i]
+		})
+
+		r := NewReassembler(4, time.Minute, ReassemblyLimits{})
+		now := time.Now()
+		for i, segment := range segments {
+			status, results := r.Add(segment, now)
+			if i < len(segments)-1 {
+				if status != SegmentAdded {
+					t.Fatalf("сегмент %d: статус %v до завершения сообщения", segment.SegmentNumber, status)
+				}
+				continue
+			}
+			if status != SegmentCompleted || len(results) != 1 {
+				t.Fatalf("последний сегмент: статус %v, результатов %d", status, len(results))
+			}
+			if got := results[0].Output; got.Error || got.Payload != payload {
+				t.Fatalf("собранная нагрузка не совпадает: error=%v, payload=%q", got.Error, got.Payload)
+			}
+		}
+		if r.Len() != 0 || r.BufferedBytes() != 0 {
+			t.Fatalf("после сборки остались сообщения: %d, байт: %d", r.Len(), r.BufferedBytes())
+		}
+	})
+}
+
+// FuzzSegmentJSON проверяет, что Segment переживает кодирование и декодирование JSON без изменений.
+func FuzzSegmentJSON(f *testing.F) {
+	f.Add(1, 1, "test_user", int64(1716258888000000000), "Hello, world!", "", segmentData)
+	f.Add(3, 5, "Пользователь", int64(0), "世界 🙂", "получатель", segmentData)
+	f.Add(1, 1, "bob", int64(1716258888000000000), `{"message_id":"bob_2024-05-21T02:34:48Z","status":"delivered"}`, "alice", segmentReceipt)
+
+	f.Fuzz(func(t *testing.T, number, total int, sender string, unixNano int64, payload, recipient, segmentType string) {
+		// JSON заменяет некорректный UTF-8 символом U+FFFD, такие строки не переживают кодирование
+		if !utf8.ValidString(sender) || !utf8.ValidString(payload) || !utf8.ValidString(recipient) || !utf8.ValidString(segmentType) {
+			return
+		}
+		segment := Segment{
+			SegmentNumber:  number,
+			TotalSegments:  total,
+			Sender:         sender,
+			SendTime:       time.Unix(0, unixNano).UTC(),
+			SegmentPayload: payload,
+			Recipient:      recipient,
+			Type:           segmentType,
+		}
+
+		data, err := json.Marshal(segment)
+		if err != nil {
+			t.Fatalf("ошибка кодирования: %v", err)
+		}
+		var decoded Segment
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("ошибка декодирования %s: %v", data, err)
+		}
+		if decoded.SegmentNumber != segment.SegmentNumber || decoded.TotalSegments != segment.TotalSegments ||
+			decoded.Sender != segment.Sender || decoded.SegmentPayload != segment.SegmentPayload ||
+			decoded.Recipient != segment.Recipient || decoded.Type != segment.Type ||
+			!decoded.SendTime.Equal(segment.SendTime) {
+			t.Fatalf("сегмент изменился: %+v -> %+v", segment, decoded)
+		}
+	})
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func TestSplitSegmentSizeLimit(t *testing.T) {
+	tests := []struct {
+		payload     string
+		segmentSize int
+		want        []string
+	}{
+		{"abcdef", 4, []string{"abcd", "ef"}},
+		{"абв", 3, []string{"а", "б", "в"}},        // Символ не разрывается
+		{"🙂🙂", 6, []string{"🙂", "🙂"}},              // Граница отступает к началу символа
+		{"🙂", 2, []string{"\xf0\x9f", "\x99\x82"}}, // Лимит меньше символа: разрез по байтам
+	}
+	for _, tt := range tests {
+		got := splitSegment(tt.payload, tt.segmentSize)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitSegment(%q, %d) = %q, ожидалось %q", tt.payload, tt.segmentSize, got, tt.want)
+		}
+		for _, part := range got {
+			if len(part) > tt.segmentSize {
+				t.Errorf("splitSegment(%q, %d): сегмент %q длиннее лимита", tt.payload, tt.segmentSize, part)
+			}
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Состояния асинхронной отправки
+const (
+	jobQueued  = "queued"  // Ожидает свободного воркера
+	jobSending = "sending" // Сегменты передаются канальному уровню
+	jobDone    = "done"    // Все сегменты переданы
+	jobFailed  = "failed"  // Часть сегментов не передана после всех попыток
+)
+
+// Состояния передачи сегмента
+const (
+	segmentPending  = "pending"
+	segmentSent     = "sent"
+	segmentRetrying = "retrying"
+	segmentFailed   = "failed"
+)
+
+// Ошибки постановки в очередь асинхронной отправки
+var (
+	errSendQueueFull    = errors.New("очередь отправки заполнена")
+	errSendQueueStopped = errors.New("сервер останавливается")
+)
+
+// sendJobMetrics - Счетчики асинхронной отправки: accepted, rejected, done, failed
+var sendJobMetrics = expvar.NewMap("send_jobs")
+
+// SendJob - Асинхронная отправка сообщения
+type SendJob struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Total      int        `json:"total_segments"`
+	Sent       int        `json:"sent"`
+	Failed     int        `json:"failed"`
+	Retrying   int        `json:"retrying"`
+	Errors     []string   `json:"errors,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	message  SendRequest
+	segments []Segment
+	states   []string // Состояние каждого сегмента
+}
+
+// sendJobQueue - Пул воркеров асинхронной отправки и хранилище результатов
+type sendJobQueue struct {
+	queue     chan *SendJob
+	slots     chan struct{} // Места для одновременной передачи сегментов всех заданий
+	workers   sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+	ctx       context.Context // Отменяется, если отправки не завершились за время остановки
+	cancel    context.CancelFunc
+
+	mu      sync.Mutex
+	jobs    map[string]*SendJob
+	stopped bool // Очередь закрыта, новые задания не принимаются
+}
+
+// Асинхронная отправка через /send
+var sendJobs = newSendJobQueue(SendQueueSize)
+
+func newSendJobQueue(size int) *sendJobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &sendJobQueue{
+		queue:  make(chan *SendJob, size),
+		slots:  make(chan struct{}, max(SendWorkers, 1)),
+		jobs:   make(map[string]*SendJob),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// sendAsync сообщает, запрошена ли асинхронная отправка (?async=true или Prefer: respond-async)
+func sendAsync(r *http.Request) bool {
+	if value := r.URL.Query().Get("async"); value != "" {
+		async, err := strconv.ParseBool(value)
+		return err == nil && async
+	}
+	return SendAsyncDefault || strings.Contains(r.Header.Get("Prefer"), "respond-async")
+}
+
+// enqueue ставит сообщение в очередь; для уже выполняемой отправки того же сообщения
+// возвращает существующее задание. Статус pending сохраняется только для принятого задания
+// и под q.mu, поэтому воркер не может записать итоговый статус раньше.
+func (q *sendJobQueue) enqueue(message SendRequest, segments []Segment, now time.Time) (SendJob, error) {
+	q.startOnce.Do(q.start)
+
+	id := messageKey(message.Sender, message.SendTime)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return SendJob{}, errSendQueueStopped
+	}
+	q.pruneLocked(now)
+	if job, ok := q.jobs[id]; ok && (job.State == jobQueued || job.State == jobSending) {
+		return *job, nil
+	}
+
+	job := &SendJob{
+		ID:        id,
+		State:     jobQueued,
+		Total:     len(segments),
+		CreatedAt: now,
+		message:   message,
+		segments:  segments,
+		states:    make([]string, len(segments)),
+	}
+	for i := range job.states {
+		job.states[i] = segmentPending
+	}
+	select {
+	case q.queue <- job:
+	default:
+		sendJobMetrics.Add("rejected", 1)
+		return SendJob{}, errSendQueueFull
+	}
+	q.jobs[id] = job
+	recordSendStatus(message, len(segments), deliveryPending, "")
+	sendJobMetrics.Add("accepted", 1)
+	return *job, nil
+}
+
+// start запускает воркеры
+func (q *sendJobQueue) start() {
+	for i := 0; i < max(SendWorkers, 1); i++ {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			for job := range q.queue {
+				q.run(job)
+			}
+		}()
+	}
+}
+
+// run передает сегменты задания канальному уровню с повторами согласно SendRetry.
+// Одновременно передается не больше SendWorkers сегментов всех заданий.
+func (q *sendJobQueue) run(job *SendJob) {
+	q.mu.Lock()
+	job.State = jobSending
+	q.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range job.segments {
+		select {
+		case q.slots <- struct{}{}:
+		case <-q.ctx.Done():
+			q.setSegmentState(job, i, segmentFailed, fmt.Errorf("сегмент %d не отправлен: %v", i+1, q.ctx.Err()))
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-q.slots
+				wg.Done()
+			}()
+			q.sendWithRetry(job, i)
+		}(i)
+	}
+	wg.Wait()
+
+	q.mu.Lock()
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	job.State = jobDone
+	if job.Failed > 0 {
+		job.State = jobFailed
+	}
+	state, errorMsg := job.State, strings.Join(job.Errors, "; ")
+	q.mu.Unlock()
+
+	sendJobMetrics.Add(state, 1)
+	if state == jobDone {
+		recordSendStatus(job.message, job.Total, deliverySent, "")
+		log.Printf("Асинхронная отправка '%s' завершена: %d сегментов", job.ID, job.Total)
+	} else {
+		recordSendStatus(job.message, job.Total, deliverySendFailed, errorMsg)
+		log.Printf("Асинхронная отправка '%s' завершена с ошибками: %s", job.ID, errorMsg)
+	}
+}
+
+// sendWithRetry передает один сегмент, обновляя счетчики задания
+func (q *sendJobQueue) sendWithRetry(job *SendJob, i int) {
+	backoff := SendRetry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := sendSegmentOnce(q.ctx, job.segments[i])
+		switch {
+		case err == nil:
+			q.setSegmentState(job, i, segmentSent, nil)
+			return
+		case attempt >= SendRetry.Attempts || q.ctx.Err() != nil:
+			q.setSegmentState(job, i, segmentFailed, err)
+			return
+		}
+		q.setSegmentState(job, i, segmentRetrying, nil)
+		log.Printf("Повтор отправки сегмента %d сообщения '%s' через %s: %v", i+1, job.ID, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-q.ctx.Done():
+		}
+		backoff = min(backoff*2, SendRetry.MaxBackoff)
+	}
+}
+
+// setSegmentState переводит сегмент в новое состояние и пересчитывает счетчики задания
+func (q *sendJobQueue) setSegmentState(job *SendJob, i int, state string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counter := func(state string) *int {
+		switch state {
+		case segmentSent:
+			return &job.Sent
+		case segmentFailed:
+			return &job.Failed
+		case segmentRetrying:
+			return &job.Retrying
+		}
+		return nil
+	}
+	if c := counter(job.states[i]); c != nil {
+		*c--
+	}
+	if c := counter(state); c != nil {
+		*c++
+	}
+	job.states[i] = state
+	if err != nil {
+		job.Errors = append(job.Errors, err.Error())
+	}
+}
+
+// get возвращает копию задания
+func (q *sendJobQueue) get(id string, now time.Time) (SendJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pruneLocked(now)
+	job, ok := q.jobs[id]
+	if !ok {
+		return SendJob{}, false
+	}
+	result := *job
+	result.Errors = append([]string(nil), job.Errors...)
+	return result, true
+}
+
+// pruneLocked удаляет завершенные задания старше SendJobRetention. Вызывается под q.mu.
+func (q *sendJobQueue) pruneLocked(now time.Time) {
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > SendJobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// stop прекращает прием заданий и ожидает воркеры не дольше timeout; возвращает незавершенные задания.
+// Канал закрывается под q.mu после установки stopped, поэтому enqueue не пишет в закрытый канал.
+func (q *sendJobQueue) stop(timeout time.Duration) []string {
+	q.stopOnce.Do(func() {
+		q.mu.Lock()
+		q.stopped = true
+		close(q.queue)
+		q.mu.Unlock()
+	})
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		q.cancel() // Прерываем зависшие запросы и повторы
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var unfinished []string
+	for id, job := range q.jobs {
+		if job.FinishedAt == nil {
+			unfinished = append(unfinished, id)
+		}
+	}
+	return unfinished
+}
+
+// StopSendJobs ожидает завершения асинхронных отправок при остановке сервера
+func StopSendJobs() {
+	if unfinished := sendJobs.stop(DrainTimeout); len(unfinished) > 0 {
+		log.Printf("Не завершены асинхронные отправки (%d): %s", len(unfinished), strings.Join(unfinished, ", "))
+	}
+}
+
+// enqueueSendJob ставит сообщение в очередь асинхронной отправки и отвечает 202 с идентификатором
+func enqueueSendJob(w http.ResponseWriter, message SendRequest, segments []Segment) {
+	job, err := sendJobs.enqueue(message, segments, time.Now())
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("Сообщение не принято: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Location", "/send/"+url.PathEscape(job.ID))
+	w.Header().Set("X-Message-ID", job.ID)
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": job.ID, "state": job.State})
+}
+
+// HandleSendStatus - GET /send/{id}: ход асинхронной отправки
+func HandleSendStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := sendJobs.get(pathVar(r, "id"), time.Now())
+	if !ok {
+		http.Error(w, "Отправка не найдена", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAsyncSend(t *testing.T) {
+	savedChannel, savedRetry, savedJobs, savedLimiter := urlChannelLevel, SendRetry, sendJobs, sendLimiter
+	t.Cleanup(func() {
+		sendJobs.stop(time.Second)
+		urlChannelLevel, SendRetry, sendJobs, sendLimiter = savedChannel, savedRetry, savedJobs, savedLimiter
+	})
+	SendRetry = RetryPolicy{Attempts: 3, Backoff: 5 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	sendJobs = newSendJobQueue(4)
+	sendLimiter = nil
+
+	// Канальный уровень отвечает ошибкой на первые два запроса
+	var requests atomic.Int32
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "временная ошибка", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer channel.Close()
+	urlChannelLevel = channel.URL
+
+	router := mux.NewRouter().UseEncodedPath()
+	router.HandleFunc("/send", HandleSend).Methods(http.MethodPost)
+	router.HandleFunc("/send/{id}", HandleSendStatus).Methods(http.MethodGet)
+	transport := httptest.NewServer(router)
+	defer transport.Close()
+
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	payload := strings.Repeat("x", 3*SegmentSize)
+	// Отправитель с "/" проверяет экранирование ключа в Location и маршруте /send/{id}
+	body, _ := json.Marshal(SendRequest{Sender: "team/async", SendTime: sendTime, Payload: payload})
+	resp, err := http.Post(transport.URL+"/send?async=true", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted struct{ ID string }
+	json.NewDecoder(resp.Body).Decode(&accepted)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || accepted.ID != messageKey("team/async", sendTime) {
+		t.Fatalf("POST /send?async=true: %s, id %q", resp.Status, accepted.ID)
+	}
+	location := resp.Header.Get("Location")
+	if location != "/send/"+url.PathEscape(accepted.ID) {
+		t.Fatalf("Location: %q", location)
+	}
+
+	var job SendJob
+	deadline := time.Now().Add(5 * time.Second)
+	for job.State != jobDone && job.State != jobFailed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		resp, err := http.Get(transport.URL + location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&job)
+		resp.Body.Close()
+	}
+	if job.State != jobDone || job.Sent != 3 || job.Failed != 0 || job.Retrying != 0 || job.FinishedAt == nil {
+		t.Errorf("задание: %+v", job)
+	}
+	if n := requests.Load(); n != 5 {
+		t.Errorf("запросов к канальному уровню %d, ожидалось 5 (3 сегмента и 2 повтора)", n)
+	}
+}
+
+func TestSendJobQueueBoundsConcurrency(t *testing.T) {
+	savedChannel, savedRetry, savedWorkers, savedLimiter := urlChannelLevel, SendRetry, SendWorkers, sendLimiter
+	t.Cleanup(func() {
+		urlChannelLevel, SendRetry, SendWorkers, sendLimiter = savedChannel, savedRetry, savedWorkers, savedLimiter
+	})
+	withReceipts(t, "")
+	SendRetry = RetryPolicy{Attempts: 1}
+	SendWorkers = 2
+	sendLimiter = nil
+
+	var active, peak atomic.Int32
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			if p := peak.Load(); n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer channel.Close()
+	urlChannelLevel = channel.URL
+
+	q := newSendJobQueue(4)
+	defer q.stop(time.Second)
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	for _, sender := range []string{"a", "b"} {
+		message := SendRequest{Sender: sender, SendTime: sendTime, Payload: strings.Repeat("x", 4*SegmentSize)}
+		segments := make([]Segment, 4)
+		for i := range segments {
+			segments[i] = Segment{SegmentNumber: i + 1, TotalSegments: 4, Sender: sender, SendTime: sendTime, SegmentPayload: "x"}
+		}
+		if _, err := q.enqueue(message, segments, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if unfinished := q.stop(5 * time.Second); len(unfinished) != 0 {
+		t.Fatalf("не завершены: %v", unfinished)
+	}
+	if n := peak.Load(); n > 2 {
+		t.Errorf("одновременно передавалось %d сегментов при SendWorkers = 2", n)
+	}
+	if status, _ := messageStatuses.get(messageKey("a", sendTime), time.Now()); status.Status != deliverySent {
+		t.Errorf("статус после отправки: %q", status.Status)
+	}
+}
+
+func TestSendJobQueueFullLeavesNoStatus(t *testing.T) {
+	withReceipts(t, "")
+	q := newSendJobQueue(0)
+	q.startOnce.Do(func() {}) // Без воркеров очередь нулевого размера всегда заполнена
+
+	message := SendRequest{Sender: "full", SendTime: time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC), Payload: "x"}
+	w := httptest.NewRecorder()
+	savedJobs := sendJobs
+	sendJobs = q
+	defer func() { sendJobs = savedJobs }()
+	enqueueSendJob(w, message, []Segment{{SegmentNumber: 1, TotalSegments: 1, Sender: "full", SendTime: message.SendTime}})
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("ответ %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if status, ok := messageStatuses.get(messageKey(message.Sender, message.SendTime), time.Now()); ok {
+		t.Errorf("отклоненное сообщение получило статус %q", status.Status)
+	}
+}
+
+func TestSendJobQueueEnqueueAfterStop(t *testing.T) {
+	withReceipts(t, "")
+	q := newSendJobQueue(4)
+	if unfinished := q.stop(time.Second); len(unfinished) != 0 {
+		t.Fatalf("незавершенные задания пустой очереди: %v", unfinished)
+	}
+
+	message := SendRequest{Sender: "late", SendTime: time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC), Payload: "x"}
+	w := httptest.NewRecorder()
+	savedJobs := sendJobs
+	sendJobs = q
+	defer func() { sendJobs = savedJobs }()
+	enqueueSendJob(w, message, []Segment{{SegmentNumber: 1, TotalSegments: 1, Sender: "late", SendTime: message.SendTime}})
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("после остановки ответ %d, ожидался 503", w.Code)
+	}
+	if _, ok := messageStatuses.get(messageKey(message.Sender, message.SendTime), time.Now()); ok {
+		t.Error("сообщение, не принятое после остановки, получило статус")
+	}
+	q.stop(time.Second) // Повторная остановка не закрывает канал снова
+}
@@ -0,0 +1,303 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink - Получатель собранных сообщений прикладного уровня
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, message OutputMessage) error
+}
+
+// RetryPolicy - Политика повторной доставки в получатель
+type RetryPolicy struct {
+	Attempts   int           // Общее количество попыток (1 - без повторов)
+	Backoff    time.Duration // Пауза перед первым повтором, далее удваивается
+	MaxBackoff time.Duration // Максимальная пауза между попытками
+}
+
+// configuredSink - Получатель с его политикой повторов
+type configuredSink struct {
+	sink  Sink
+	retry RetryPolicy
+}
+
+// errSinkUnavailable - Получатель больше не принимает сообщения, повторы бессмысленны
+var errSinkUnavailable = errors.New("получатель недоступен")
+
+// sinkMetrics - Счетчики получателей: <name>.delivered, <name>.failed, <name>.retries
+var sinkMetrics = expvar.NewMap("sinks")
+
+// Получатели собранных сообщений и политика повторов по умолчанию
+var (
+	sinkRetry = envRetryPolicy("TRANSPORT_SINK_RETRY", "attempts=3,backoff=500ms,max_backoff=5s")
+	sinks     = envSinks("TRANSPORT_SINKS", "http,websocket,receipt", sinkRetry)
+)
+
+// deliverToSink доставляет сообщение в получатель с повторами согласно политике
+func deliverToSink(s configuredSink, message OutputMessage) {
+	name := s.sink.Name()
+	backoff := s.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := s.sink.Deliver(context.Background(), message)
+		if err == nil {
+			sinkMetrics.Add(name+".delivered", 1)
+			return
+		}
+		if attempt >= s.retry.Attempts || errors.Is(err, errSinkUnavailable) {
+			sinkMetrics.Add(name+".failed", 1)
+			log.Printf("Не удалось доставить сообщение от '%s' в %s после %d попыток: %v", message.Sender, name, attempt, err)
+			return
+		}
+
+		sinkMetrics.Add(name+".retries", 1)
+		log.Printf("Ошибка доставки в %s (попытка %d из %d), повтор через %s: %v", name, attempt, s.retry.Attempts, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, s.retry.MaxBackoff)
+	}
+}
+
+// CloseSinks закрывает получателей, удерживающих ресурсы (файлы)
+func CloseSinks() {
+	for _, s := range sinks {
+		if closer, ok := s.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Ошибка при закрытии получателя %s: %v", s.sink.Name(), err)
+			}
+		}
+	}
+}
+
+// --- Реализации получателей ---
+
+// httpSink отправляет сообщение POST запросом (пустой url - текущий urlApplLevel)
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) target() string {
+	if s.url != "" {
+		return s.url
+	}
+	return urlApplLevel
+}
+
+func (s *httpSink) Name() string { return "http:" + s.target() }
+
+func (s *httpSink) Deliver(ctx context.Context, message OutputMessage) error {
+	log.Printf("[<-] Сообщение, отправляемое на прикладной уровень: %+v", message)
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("ошибка при маршалинге сообщения: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.target(), bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("ошибка при создании POST запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	breaker := breakerFor(s.target())
+	generation, err := breaker.allow(time.Now())
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		breaker.record(generation, breakerResult(err, 0, ctx.Err() != nil), time.Now())
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	breaker.record(generation, breakerResult(nil, resp.StatusCode, false), time.Now())
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("некорректный статус ответа: %d", resp.StatusCode)
+	}
+	log.Printf("Сообщение успешно отправлено на %s", s.target())
+	return nil
+}
+
+// kafkaSink записывает сообщение в выходной топик Kafka
+type kafkaSink struct {
+	topic string
+}
+
+func (s *kafkaSink) Name() string { return "kafka:" + s.topic }
+
+func (s *kafkaSink) Deliver(ctx context.Context, message OutputMessage) error {
+	value, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return writeKafka(s.topic, nil, value)
+}
+
+// websocketSink передает сообщение подписчикам /ws
+type websocketSink struct{}
+
+func (websocketSink) Name() string { return "websocket" }
+
+func (websocketSink) Deliver(ctx context.Context, message OutputMessage) error {
+	subscribers.Broadcast(message)
+	return nil
+}
+
+// fileSink дописывает сообщения в файл в формате JSONL (writer - os.Stdout для stdout)
+type fileSink struct {
+	name string
+	path string
+
+	mu     sync.Mutex
+	writer io.Writer
+	file   *os.File
+}
+
+func (s *fileSink) Name() string { return s.name }
+
+func (s *fileSink) Deliver(ctx context.Context, message OutputMessage) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writer == nil {
+		f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		s.file, s.writer = f, f
+	}
+	_, err = s.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file, s.writer = nil, nil
+	return err
+}
+
+// --- Конфигурация получателей ---
+
+// envSinks читает список получателей из переменной окружения; при ошибке используется def
+func envSinks(key, def string, retry RetryPolicy) []configuredSink {
+	value := envString(key, def)
+	result, err := parseSinks(value, retry)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %q: %v", key, value, def, err)
+		result, _ = parseSinks(def, retry)
+	}
+	return result
+}
+
+// parseSinks разбирает список вида "http,kafka:messages-out;attempts=5,file:delivered.jsonl".
+// Элемент: тип[:назначение][;параметр=значение...]; параметры задают политику повторов получателя.
+func parseSinks(value string, defRetry RetryPolicy) ([]configuredSink, error) {
+	var result []configuredSink
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		spec, options, _ := strings.Cut(item, ";")
+		kind, target, _ := strings.Cut(spec, ":")
+
+		var sink Sink
+		switch kind {
+		case "http":
+			sink = &httpSink{url: target, client: outboundHTTP}
+		case "kafka":
+			if target == "" {
+				return nil, errors.New("для kafka не указан топик")
+			}
+			sink = &kafkaSink{topic: target}
+		case "websocket":
+			sink = websocketSink{}
+		case "file":
+			if target == "" {
+				return nil, errors.New("для file не указан путь")
+			}
+			sink = &fileSink{name: "file:" + target, path: target}
+		case "stdout":
+			sink = &fileSink{name: "stdout", writer: os.Stdout}
+		case "receipt":
+			sink = receiptSink{}
+		default:
+			return nil, fmt.Errorf("неизвестный тип получателя %q", kind)
+		}
+
+		retry := defRetry
+		if options != "" {
+			var err error
+			if retry, err = parseRetryPolicy(strings.ReplaceAll(options, ";", ","), defRetry); err != nil {
+				return nil, fmt.Errorf("%s: %v", kind, err)
+			}
+		}
+		result = append(result, configuredSink{sink: sink, retry: retry})
+	}
+	if len(result) == 0 {
+		return nil, errors.New("не задан ни один получатель")
+	}
+	return result, nil
+}
+
+// envRetryPolicy читает политику повторов из переменной окружения; при ошибке используется def
+func envRetryPolicy(key, def string) RetryPolicy {
+	value := envString(key, def)
+	policy, err := parseRetryPolicy(value, RetryPolicy{Attempts: 1})
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %q: %v", key, value, def, err)
+		policy, _ = parseRetryPolicy(def, RetryPolicy{Attempts: 1})
+	}
+	return policy
+}
+
+// parseRetryPolicy разбирает "attempts=N,backoff=D,max_backoff=D", дополняя значения из base
+func parseRetryPolicy(value string, base RetryPolicy) (RetryPolicy, error) {
+	policy := base
+	for _, part := range strings.Split(value, ",") {
+		name, raw, _ := strings.Cut(strings.TrimSpace(part), "=")
+		var err error
+		switch name {
+		case "attempts":
+			policy.Attempts, err = strconv.Atoi(raw)
+		case "backoff":
+			policy.Backoff, err = time.ParseDuration(raw)
+		case "max_backoff":
+			policy.MaxBackoff, err = time.ParseDuration(raw)
+		default:
+			err = fmt.Errorf("неизвестный параметр %q", name)
+		}
+		if err != nil {
+			return RetryPolicy{}, err
+		}
+	}
+	if policy.Attempts < 1 {
+		return RetryPolicy{}, errors.New("attempts должно быть не меньше 1")
+	}
+	policy.MaxBackoff = max(policy.MaxBackoff, policy.Backoff)
+	return policy, nil
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSinks(t *testing.T) {
+	def := RetryPolicy{Attempts: 3, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		value     string
+		wantNames []string
+		wantRetry []int // Количество попыток каждого получателя
+		wantErr   bool
+	}{
+		{value: "http,websocket", wantNames: []string{"http:" + urlApplLevel, "websocket"}, wantRetry: []int{3, 3}},
+		{
+			value:     "http:http://appl/receive;attempts=5;backoff=10ms, kafka:messages-out, file:out.jsonl;attempts=1, stdout",
+			wantNames: []string{"http:http://appl/receive", "kafka:messages-out", "file:out.jsonl", "stdout"},
+			wantRetry: []int{5, 3, 1, 3},
+		},
+		{value: "kafka", wantErr: true},
+		{value: "smtp:admin@example.com", wantErr: true},
+		{value: "http;attempts=0", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSinks(tt.value, def)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: ожидалась ошибка", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.value, err)
+			continue
+		}
+		for i, s := range got {
+			if s.sink.Name() != tt.wantNames[i] || s.retry.Attempts != tt.wantRetry[i] {
+				t.Errorf("%q: получатель %d = %s (попыток %d)", tt.value, i, s.sink.Name(), s.retry.Attempts)
+			}
+		}
+	}
+}
+
+// flakySink отвечает ошибкой на первые failures попыток
+type flakySink struct {
+	failures int
+	calls    int
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Deliver(ctx context.Context, message OutputMessage) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("недоступен")
+	}
+	return nil
+}
+
+func TestDeliverToSinkRetries(t *testing.T) {
+	retry := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	recovered := &flakySink{failures: 2}
+	deliverToSink(configuredSink{sink: recovered, retry: retry}, OutputMessage{Sender: "a"})
+	if recovered.calls != 3 {
+		t.Errorf("восстановившийся получатель: %d попыток, ожидалось 3", recovered.calls)
+	}
+
+	broken := &flakySink{failures: 10}
+	deliverToSink(configuredSink{sink: broken, retry: retry}, OutputMessage{Sender: "a"})
+	if broken.calls != 3 {
+		t.Errorf("неисправный получатель: %d попыток, ожидалось 3", broken.calls)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delivered.jsonl")
+	sink := &fileSink{name: "file:" + path, path: path}
+	for _, sender := range []string{"a", "b"} {
+		if err := sink.Deliver(context.Background(), OutputMessage{Sender: sender, Payload: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"sender":"b"`) {
+		t.Errorf("содержимое файла: %s", data)
+	}
+}
@@ -0,0 +1,442 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Заголовки подписанных уведомлений webhook.
+// Подпись: "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + тело запроса)).
+const (
+	webhookTimestampHeader    = "X-Transport-Timestamp"
+	webhookSignatureHeader    = "X-Transport-Signature"
+	webhookSubscriptionHeader = "X-Transport-Subscription"
+)
+
+// Subscription - Подписка прикладного уровня на собранные сообщения
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Sender    string    `json:"sender,omitempty"`    // Фильтр по отправителю (пусто - все)
+	Recipient string    `json:"recipient,omitempty"` // Фильтр по получателю (пусто - все)
+	Secret    string    `json:"secret,omitempty"`    // Ключ подписи; в списке подписок не возвращается
+	CreatedAt time.Time `json:"created_at"`
+	Disabled  bool      `json:"disabled"`
+	Failures  int       `json:"failures"` // Неудачных попыток доставки подряд
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// matches проверяет, подходит ли сообщение под фильтры подписки
+func (s *Subscription) matches(message OutputMessage) bool {
+	return (s.Sender == "" || s.Sender == message.Sender) && (s.Recipient == "" || s.Recipient == message.Recipient)
+}
+
+// subscriptionRegistry - Реестр подписок, сохраняемый в файл
+type subscriptionRegistry struct {
+	path string
+
+	mu   sync.Mutex
+	subs map[string]*Subscription
+}
+
+// Подписки webhook
+var webhooks = &subscriptionRegistry{path: SubscriptionsFile, subs: make(map[string]*Subscription)}
+
+// load читает подписки из файла; отсутствие файла не является ошибкой
+func (r *subscriptionRegistry) load() error {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var list []*Subscription
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range list {
+		r.subs[s.ID] = s
+	}
+	return nil
+}
+
+// saveLocked атомарно сохраняет подписки в файл. Вызывается под r.mu.
+func (r *subscriptionRegistry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// listLocked возвращает подписки, упорядоченные по времени создания. Вызывается под r.mu.
+func (r *subscriptionRegistry) listLocked() []*Subscription {
+	list := make([]*Subscription, 0, len(r.subs))
+	for _, s := range r.subs {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// add регистрирует подписку и сохраняет реестр
+func (r *subscriptionRegistry) add(s *Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs[s.ID] = s
+	return r.saveLocked()
+}
+
+// update изменяет подписку под блокировкой и сохраняет реестр; false, если подписки нет
+func (r *subscriptionRegistry) update(id string, fn func(s *Subscription)) (Subscription, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.subs[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	fn(s)
+	if err := r.saveLocked(); err != nil {
+		log.Printf("Ошибка сохранения подписок в %s: %v", r.path, err)
+	}
+	return *s, true
+}
+
+// remove удаляет подписку; false, если подписки нет
+func (r *subscriptionRegistry) remove(id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subs[id]; !ok {
+		return false, nil
+	}
+	delete(r.subs, id)
+	return true, r.saveLocked()
+}
+
+// list возвращает копии всех подписок без ключей подписи
+func (r *subscriptionRegistry) list() []Subscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]Subscription, 0, len(r.subs))
+	for _, s := range r.listLocked() {
+		result = append(result, publicSubscription(*s))
+	}
+	return result
+}
+
+// get возвращает копию подписки
+func (r *subscriptionRegistry) get(id string) (Subscription, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.subs[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *s, true
+}
+
+// matching возвращает копии активных подписок, подходящих под сообщение
+func (r *subscriptionRegistry) matching(message OutputMessage) []Subscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []Subscription
+	for _, s := range r.listLocked() {
+		if !s.Disabled && s.matches(message) {
+			result = append(result, *s)
+		}
+	}
+	return result
+}
+
+// recordResult учитывает результат попытки доставки и отключает подписку после
+// WebhookMaxFailures неудачных попыток подряд. Файл перезаписывается только при отключении,
+// счетчик неудач сохраняется вместе со следующим изменением реестра.
+func (r *subscriptionRegistry) recordResult(id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.subs[id]
+	if !ok {
+		return
+	}
+	if err == nil {
+		s.Failures, s.LastError = 0, ""
+		return
+	}
+	s.Failures++
+	s.LastError = err.Error()
+	if WebhookMaxFailures <= 0 || s.Failures < WebhookMaxFailures || s.Disabled {
+		return
+	}
+	s.Disabled = true
+	log.Printf("Подписка %s (%s) отключена после %d неудачных попыток: %v", s.ID, s.URL, s.Failures, err)
+	if err := r.saveLocked(); err != nil {
+		log.Printf("Ошибка сохранения подписок в %s: %v", r.path, err)
+	}
+}
+
+// webhookURLAllowed проверяет адрес webhook по списку WebhookAllowedHosts
+func webhookURLAllowed(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	allowed := WebhookAllowedHosts
+	if allowed == "" {
+		appl, err := url.Parse(urlApplLevel)
+		if err != nil {
+			return false
+		}
+		allowed = appl.Host
+	}
+	for _, host := range strings.Split(allowed, ",") {
+		host = strings.TrimSpace(host)
+		if host == "*" || strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookSinks возвращает получателей для подписок, подходящих под сообщение
+func webhookSinks(message OutputMessage) []configuredSink {
+	var result []configuredSink
+	for _, s := range webhooks.matching(message) {
+		result = append(result, configuredSink{sink: &webhookSink{sub: s}, retry: sinkRetry})
+	}
+	return result
+}
+
+// webhookSink доставляет сообщение подписке с подписью HMAC
+type webhookSink struct {
+	sub Subscription
+}
+
+func (s *webhookSink) Name() string { return "webhook:" + s.sub.ID }
+
+func (s *webhookSink) Deliver(ctx context.Context, message OutputMessage) error {
+	if current, ok := webhooks.get(s.sub.ID); !ok || current.Disabled {
+		return errSinkUnavailable // Подписка удалена или отключена во время повторов
+	}
+	if !webhookURLAllowed(s.sub.URL) {
+		return errSinkUnavailable // Адрес исключен из WebhookAllowedHosts после регистрации
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSubscriptionHeader, s.sub.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(s.sub.Secret, timestamp, body))
+
+	// Разомкнутый выключатель не считается неудачей подписки: адрес уже известен как недоступный
+	breaker := breakerFor(s.sub.URL)
+	generation, err := breaker.allow(time.Now())
+	if err != nil {
+		return err
+	}
+	resp, err := outboundHTTP.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		breaker.record(generation, breakerResult(nil, resp.StatusCode, false), time.Now())
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("некорректный статус ответа: %d", resp.StatusCode)
+		}
+	} else {
+		breaker.record(generation, breakerResult(err, 0, ctx.Err() != nil), time.Now())
+	}
+	webhooks.recordResult(s.sub.ID, err)
+	return err
+}
+
+// signWebhook вычисляет подпись тела уведомления
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature проверяет подпись уведомления webhook и давность метки времени
+// (не больше tolerance), защищая получателя от подделки и повторного воспроизведения.
+func VerifyWebhookSignature(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("некорректная метка времени: %v", err)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("метка времени отличается от текущего времени на %s", age.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(signature), []byte(signWebhook(secret, timestamp, body))) {
+		return errors.New("неверная подпись")
+	}
+	return nil
+}
+
+// randomHex возвращает случайную строку из n байт в шестнадцатеричном виде
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// --- API подписок ---
+
+// SubscriptionRequest - Тело запроса POST /subscriptions
+type SubscriptionRequest struct {
+	URL       string `json:"url"`
+	Sender    string `json:"sender,omitempty"`
+	Recipient string `json:"recipient,omitempty"`
+	Secret    string `json:"secret,omitempty"` // Пусто - ключ генерируется и возвращается один раз
+}
+
+// RegisterSubscriptionRoutes загружает сохраненные подписки и регистрирует эндпоинты /subscriptions
+func RegisterSubscriptionRoutes(r *mux.Router) {
+	if err := webhooks.load(); err != nil {
+		log.Printf("Ошибка загрузки подписок из %s: %v", webhooks.path, err)
+	}
+
+	subs := r.PathPrefix("/subscriptions").Subrouter()
+	subs.Use(subscriptionsAuth)
+	subs.HandleFunc("", handleSubscriptionCreate).Methods(http.MethodPost)
+	subs.HandleFunc("", handleSubscriptionList).Methods(http.MethodGet)
+	subs.HandleFunc("/{id}", handleSubscriptionGet).Methods(http.MethodGet)
+	subs.HandleFunc("/{id}", handleSubscriptionDelete).Methods(http.MethodDelete)
+	subs.HandleFunc("/{id}/enable", handleSubscriptionEnable).Methods(http.MethodPost)
+}
+
+// subscriptionsAuth проверяет токен SubscriptionsToken; без токена API подписок отключен
+func subscriptionsAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if SubscriptionsToken == "" {
+			http.Error(w, "API подписок отключен", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(SubscriptionsToken)) != 1 {
+			http.Error(w, "Неверный токен доступа", http.StatusUnauthorized)
+			log.Printf("Отклонен запрос к API подписок: %s %s", r.Method, r.URL)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publicSubscription скрывает ключ подписи
+func publicSubscription(s Subscription) Subscription {
+	s.Secret = ""
+	return s
+}
+
+func handleSubscriptionCreate(w http.ResponseWriter, r *http.Request) {
+	var req SubscriptionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	if !webhookURLAllowed(req.URL) {
+		http.Error(w, "Некорректный или неразрешенный URL подписки (TRANSPORT_WEBHOOK_ALLOWED_HOSTS)", http.StatusBadRequest)
+		return
+	}
+
+	sub := &Subscription{
+		ID:        randomHex(8),
+		URL:       req.URL,
+		Sender:    req.Sender,
+		Recipient: req.Recipient,
+		Secret:    req.Secret,
+		CreatedAt: time.Now().UTC(),
+	}
+	if sub.Secret == "" {
+		sub.Secret = randomHex(32)
+	}
+	if err := webhooks.add(sub); err != nil {
+		log.Printf("Ошибка сохранения подписок в %s: %v", webhooks.path, err)
+		http.Error(w, "Не удалось сохранить подписку", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Зарегистрирована подписка %s на %s (sender=%q, recipient=%q)", sub.ID, sub.URL, sub.Sender, sub.Recipient)
+	// Ключ подписи возвращается только при создании
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func handleSubscriptionList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, webhooks.list())
+}
+
+func handleSubscriptionGet(w http.ResponseWriter, r *http.Request) {
+	sub, ok := webhooks.get(pathVar(r, "id"))
+	if !ok {
+		http.Error(w, "Подписка не найдена", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, publicSubscription(sub))
+}
+
+func handleSubscriptionDelete(w http.ResponseWriter, r *http.Request) {
+	id := pathVar(r, "id")
+	ok, err := webhooks.remove(id)
+	if !ok {
+		http.Error(w, "Подписка не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения подписок в %s: %v", webhooks.path, err)
+	}
+	log.Printf("Удалена подписка %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleSubscriptionEnable(w http.ResponseWriter, r *http.Request) {
+	sub, ok := webhooks.update(pathVar(r, "id"), func(s *Subscription) {
+		s.Disabled, s.Failures, s.LastError = false, 0, ""
+	})
+	if !ok {
+		http.Error(w, "Подписка не найдена", http.StatusNotFound)
+		return
+	}
+	log.Printf("Подписка %s снова включена", sub.ID)
+	writeJSON(w, http.StatusOK, publicSubscription(sub))
+}
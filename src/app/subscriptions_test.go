@@ -0,0 +1,137 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWebhookSubscriptions(t *testing.T) {
+	savedRegistry, savedMax, savedRetry := webhooks, WebhookMaxFailures, sinkRetry
+	savedToken, savedHosts := SubscriptionsToken, WebhookAllowedHosts
+	t.Cleanup(func() {
+		webhooks, WebhookMaxFailures, sinkRetry = savedRegistry, savedMax, savedRetry
+		SubscriptionsToken, WebhookAllowedHosts = savedToken, savedHosts
+	})
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	webhooks = &subscriptionRegistry{path: path, subs: make(map[string]*Subscription)}
+	WebhookMaxFailures = 2
+	sinkRetry = RetryPolicy{Attempts: 1}
+	SubscriptionsToken, WebhookAllowedHosts = "", "127.0.0.1"
+
+	r := mux.NewRouter().UseEncodedPath()
+	RegisterSubscriptionRoutes(r)
+	transport := httptest.NewServer(r)
+	defer transport.Close()
+
+	good := NewApplStub(ApplStubConfig{Secret: "s3cret"}, nil)
+	goodSrv := httptest.NewServer(good.Handler())
+	defer goodSrv.Close()
+	broken := NewApplStub(ApplStubConfig{FailProb: 1}, nil)
+	brokenSrv := httptest.NewServer(broken.Handler())
+	defer brokenSrv.Close()
+
+	post := func(req SubscriptionRequest, token string) *http.Response {
+		body, _ := json.Marshal(req)
+		r, _ := http.NewRequest(http.MethodPost, transport.URL+"/subscriptions", bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	create := func(req SubscriptionRequest) Subscription {
+		resp := post(req, "token")
+		defer resp.Body.Close()
+		var sub Subscription
+		if resp.StatusCode != http.StatusCreated || json.NewDecoder(resp.Body).Decode(&sub) != nil {
+			t.Fatalf("POST /subscriptions: %s", resp.Status)
+		}
+		return sub
+	}
+
+	// Без токена API отключен; неверный токен и неразрешенный адрес отклоняются
+	checks := []struct {
+		name        string
+		serverToken string
+		token       string
+		url         string
+		status      int
+	}{
+		{"токен не задан", "", "", goodSrv.URL, http.StatusForbidden},
+		{"неверный токен", "token", "wrong", goodSrv.URL, http.StatusUnauthorized},
+		{"неразрешенный адрес", "token", "token", "http://169.254.169.254/latest", http.StatusBadRequest},
+	}
+	for _, c := range checks {
+		SubscriptionsToken = c.serverToken
+		resp := post(SubscriptionRequest{URL: c.url}, c.token)
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: %s, ожидался %d", c.name, resp.Status, c.status)
+		}
+	}
+	SubscriptionsToken = "token"
+	bob := create(SubscriptionRequest{URL: goodSrv.URL + "/receive", Recipient: "bob", Secret: "s3cret"})
+	failing := create(SubscriptionRequest{URL: brokenSrv.URL + "/receive"})
+	if failing.Secret == "" {
+		t.Error("ключ подписи не сгенерирован")
+	}
+
+	deliver := func(message OutputMessage) {
+		for _, s := range webhookSinks(message) {
+			deliverToSink(s, message)
+		}
+	}
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	deliver(OutputMessage{Sender: "alice", SendTime: sendTime, Recipient: "bob", Payload: "1"})
+	deliver(OutputMessage{Sender: "alice", SendTime: sendTime, Recipient: "carol", Payload: "2"})
+
+	// Заглушка с ключом принимает только подписанные сообщения своего получателя
+	if received := good.Received(); len(received) != 1 || received[0].Message.Payload != "1" {
+		t.Errorf("подписка bob получила %+v", received)
+	}
+	// Подписка без фильтра отключается после двух неудач подряд
+	if sub, _ := webhooks.get(failing.ID); !sub.Disabled || sub.Failures != 2 {
+		t.Errorf("подписка не отключена: %+v", sub)
+	}
+	deliver(OutputMessage{Sender: "alice", SendTime: sendTime, Payload: "3"})
+	if n := len(broken.Received()); n != 2 {
+		t.Errorf("отключенная подписка получила %d запросов, ожидалось 2", n)
+	}
+
+	// Подписки сохраняются в файл
+	reloaded := &subscriptionRegistry{path: path, subs: make(map[string]*Subscription)}
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if sub, ok := reloaded.get(bob.ID); !ok || sub.Secret != "s3cret" || sub.Recipient != "bob" {
+		t.Errorf("загруженная подписка: %+v", sub)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"sender":"a"}`)
+	timestamp := "1700000000"
+	signature := signWebhook("key", timestamp, body)
+
+	if err := VerifyWebhookSignature("key", timestamp, signature, body, time.Minute, now); err != nil {
+		t.Errorf("корректная подпись отклонена: %v", err)
+	}
+	if VerifyWebhookSignature("other", timestamp, signature, body, time.Minute, now) == nil {
+		t.Error("принята подпись с другим ключом")
+	}
+	if VerifyWebhookSignature("key", timestamp, signature, []byte(`{"sender":"b"}`), time.Minute, now) == nil {
+		t.Error("принято измененное тело")
+	}
+	if VerifyWebhookSignature("key", timestamp, signature, body, time.Minute, now.Add(2*time.Minute)) == nil {
+		t.Error("принята устаревшая метка времени")
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Компонент проверки готовности для топиков Kafka
+const readinessKafkaTopics = "kafka_topics"
+
+// topicSpec - Требования к топику
+type topicSpec struct {
+	name          string
+	minPartitions int // Минимально необходимое количество разделов
+}
+
+// requiredTopics возвращает топики, без которых транспортный уровень не может работать
+func requiredTopics() []topicSpec {
+	topics := []topicSpec{{name: KafkaTopic, minPartitions: ReassemblyConsumers}}
+	if DLQTopic != "" {
+		topics = append(topics, topicSpec{name: DLQTopic, minPartitions: 1})
+	}
+	return topics
+}
+
+// EnsureTopics проверяет наличие топиков и достаточность разделов для ReassemblyConsumers.
+// Отсутствующие топики создаются, если включен KafkaAutoCreateTopics.
+func EnsureTopics(ctx context.Context) error {
+	config, err := kafkaConfigMap(nil)
+	if err != nil {
+		return err
+	}
+	admin, err := confluent.NewAdminClient(config)
+	if err != nil {
+		return fmt.Errorf("не удалось создать Kafka admin client: %v", err)
+	}
+	defer admin.Close()
+
+	metadata, err := admin.GetMetadata(nil, true, 5000)
+	if err != nil {
+		return fmt.Errorf("не удалось получить метаданные Kafka (%s): %v", KafkaAddr, err)
+	}
+
+	missing, err := missingTopics(metadata.Topics)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+
+	createCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	results, err := admin.CreateTopics(createCtx, missing)
+	if err != nil {
+		return fmt.Errorf("не удалось создать топики: %v", err)
+	}
+	for _, result := range results {
+		if code := result.Error.Code(); code != confluent.ErrNoError && code != confluent.ErrTopicAlreadyExists {
+			return fmt.Errorf("не удалось создать топик %s: %v", result.Topic, result.Error)
+		}
+		log.Printf("Создан топик Kafka %s", result.Topic)
+	}
+	return nil
+}
+
+// missingTopics сверяет метаданные кластера с requiredTopics и возвращает топики, которые нужно создать.
+// Ошибка означает, что топика нет и создание отключено, или что у топика недостаточно разделов.
+func missingTopics(topics map[string]confluent.TopicMetadata) ([]confluent.TopicSpecification, error) {
+	var missing []confluent.TopicSpecification
+	for _, topic := range requiredTopics() {
+		meta, ok := topics[topic.name]
+		if !ok || meta.Error.Code() == confluent.ErrUnknownTopicOrPart || len(meta.Partitions) == 0 {
+			if !KafkaAutoCreateTopics {
+				return nil, fmt.Errorf("топик %s не существует; создайте его вручную или включите TRANSPORT_KAFKA_AUTO_CREATE", topic.name)
+			}
+			spec := confluent.TopicSpecification{
+				Topic:             topic.name,
+				NumPartitions:     max(KafkaTopicPartitions, topic.minPartitions),
+				ReplicationFactor: KafkaReplicationFactor,
+				Config:            map[string]string{},
+			}
+			if KafkaRetention > 0 {
+				spec.Config["retention.ms"] = strconv.FormatInt(KafkaRetention.Milliseconds(), 10)
+			}
+			missing = append(missing, spec)
+			continue
+		}
+		if meta.Error.Code() != confluent.ErrNoError {
+			return nil, fmt.Errorf("ошибка метаданных топика %s: %v", topic.name, meta.Error)
+		}
+		if len(meta.Partitions) < topic.minPartitions {
+			return nil, fmt.Errorf("у топика %s %d разделов, а для %d потребителей сборки (TRANSPORT_REASSEMBLY_CONSUMERS) нужно не меньше %d",
+				topic.name, len(meta.Partitions), ReassemblyConsumers, topic.minPartitions)
+		}
+	}
+	return missing, nil
+}
+
+// waitForTopics повторяет проверку топиков до успеха или отмены контекста, обновляя готовность.
+// Возвращает false, если контекст отменен раньше.
+func waitForTopics(ctx context.Context) bool {
+	for {
+		err := EnsureTopics(ctx)
+		setReadiness(readinessKafkaTopics, err)
+		if err == nil {
+			log.Println("Топики Kafka проверены.")
+			return true
+		}
+		log.Printf("Транспортный уровень не готов: %v. Повтор через %s", err, topicCheckInterval)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(topicCheckInterval):
+		}
+	}
+}
+
+// topicCheckInterval - Интервал повторной проверки топиков при неготовности
+const topicCheckInterval = 10 * time.Second
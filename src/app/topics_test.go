@@ -0,0 +1,141 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/segmentio/kafka-go"
+)
+
+// withTopicSettings задает параметры топиков на время теста
+func withTopicSettings(t *testing.T, autoCreate bool, consumers int) {
+	t.Helper()
+	savedDLQ, savedAutoCreate, savedConsumers := DLQTopic, KafkaAutoCreateTopics, ReassemblyConsumers
+	savedPartitions, savedRetention := KafkaTopicPartitions, KafkaRetention
+	t.Cleanup(func() {
+		DLQTopic, KafkaAutoCreateTopics, ReassemblyConsumers = savedDLQ, savedAutoCreate, savedConsumers
+		KafkaTopicPartitions, KafkaRetention = savedPartitions, savedRetention
+	})
+	DLQTopic, KafkaAutoCreateTopics, ReassemblyConsumers = "segments-dlq", autoCreate, consumers
+	KafkaTopicPartitions, KafkaRetention = 1, time.Hour
+}
+
+// topicMeta формирует метаданные топика с partitions разделами и кодом ошибки code
+func topicMeta(name string, partitions int, code confluent.ErrorCode) confluent.TopicMetadata {
+	return confluent.TopicMetadata{
+		Topic:      name,
+		Partitions: make([]confluent.PartitionMetadata, partitions),
+		Error:      confluent.NewError(code, "", false),
+	}
+}
+
+func TestMissingTopics(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoCreate bool
+		consumers  int
+		topics     []confluent.TopicMetadata
+		want       map[string]int // Создаваемый топик -> количество разделов
+		wantErr    string
+	}{
+		{
+			name:       "все топики есть",
+			autoCreate: true, consumers: 2,
+			topics: []confluent.TopicMetadata{topicMeta(KafkaTopic, 2, confluent.ErrNoError), topicMeta("segments-dlq", 1, confluent.ErrNoError)},
+		},
+		{
+			name:       "создание отсутствующих с разделами для потребителей",
+			autoCreate: true, consumers: 3,
+			topics: []confluent.TopicMetadata{topicMeta("segments-dlq", 0, confluent.ErrUnknownTopicOrPart)},
+			want:   map[string]int{KafkaTopic: 3, "segments-dlq": 1},
+		},
+		{
+			name:       "создание отключено",
+			autoCreate: false, consumers: 1,
+			topics:  []confluent.TopicMetadata{topicMeta(KafkaTopic, 1, confluent.ErrNoError)},
+			wantErr: "segments-dlq не существует",
+		},
+		{
+			name:       "недостаточно разделов",
+			autoCreate: true, consumers: 4,
+			topics:  []confluent.TopicMetadata{topicMeta(KafkaTopic, 2, confluent.ErrNoError), topicMeta("segments-dlq", 1, confluent.ErrNoError)},
+			wantErr: "2 разделов",
+		},
+		{
+			name:       "ошибка метаданных",
+			autoCreate: true, consumers: 1,
+			topics:  []confluent.TopicMetadata{topicMeta(KafkaTopic, 1, confluent.ErrTopicAuthorizationFailed)},
+			wantErr: "ошибка метаданных топика " + KafkaTopic,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTopicSettings(t, tt.autoCreate, tt.consumers)
+			metadata := make(map[string]confluent.TopicMetadata)
+			for _, topic := range tt.topics {
+				metadata[topic.Topic] = topic
+			}
+
+			missing, err := missingTopics(metadata)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась с %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(missing) != len(tt.want) {
+				t.Fatalf("создаются %+v, ожидалось %v", missing, tt.want)
+			}
+			for _, spec := range missing {
+				if spec.NumPartitions != tt.want[spec.Topic] || spec.Config["retention.ms"] != "3600000" {
+					t.Errorf("топик %s: %d разделов, конфигурация %v", spec.Topic, spec.NumPartitions, spec.Config)
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaWriterAutoCreate(t *testing.T) {
+	for _, autoCreate := range []bool{true, false} {
+		withTopicSettings(t, autoCreate, 1)
+		writer, err := newKafkaWriter(KafkaTopic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if writer.AllowAutoTopicCreation != autoCreate {
+			t.Errorf("KafkaAutoCreateTopics = %v, AllowAutoTopicCreation = %v", autoCreate, writer.AllowAutoTopicCreation)
+		}
+	}
+}
+
+func TestKafkaWriterKeepsMessageInOnePartition(t *testing.T) {
+	withTopicSettings(t, true, 4)
+	writer, err := newKafkaWriter(KafkaTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitions := []int{0, 1, 2, 3}
+
+	seen := make(map[string]int) // Ключ сообщения -> раздел
+	used := make(map[int]bool)
+	for _, sender := range []string{"alice", "bob", "carol", "dave", "eve", "frank", "grace", "heidi"} {
+		for number := 1; number <= 5; number++ {
+			segment := testSegment(sender, number, 5, "часть")
+			partition := writer.Balancer.Balance(kafka.Message{Key: segmentKey(segment)}, partitions...)
+			key := messageKey(segment.Sender, segment.SendTime)
+			if first, ok := seen[key]; ok && first != partition {
+				t.Fatalf("сегмент %d сообщения %s записан в раздел %d, предыдущие - в %d", number, key, partition, first)
+			}
+			seen[key] = partition
+			used[partition] = true
+		}
+	}
+	if len(used) < 2 {
+		t.Errorf("сообщения разных отправителей не распределяются по разделам: %v", used)
+	}
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Параметры соединений WebSocket
+const (
+	wsWriteWait      = 10 * time.Second    // Время на запись одного кадра
+	wsPongWait       = 60 * time.Second    // Время ожидания pong от клиента
+	wsPingPeriod     = wsPongWait * 9 / 10 // Период отправки ping, меньше wsPongWait
+	wsMaxReadMessage = 512                 // Клиенты не отправляют данных, кроме управляющих кадров
+)
+
+// wsMetrics - Счетчики WebSocket: clients (подключено), sent, dropped (медленные клиенты отключены)
+var wsMetrics = expvar.NewMap("websocket")
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     wsCheckOrigin,
+}
+
+// wsCheckOrigin разрешает подключение со страниц из WSAllowedOrigins; без Origin (не браузер) - всегда
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || WSAllowedOrigins == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(WSAllowedOrigins, ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeStream проверяет токен StreamsToken для /ws и /events. Браузерные WebSocket и EventSource
+// не передают заголовки, поэтому токен принимается и в параметре ?token=.
+func authorizeStream(w http.ResponseWriter, r *http.Request) bool {
+	if StreamsToken == "" {
+		http.Error(w, "Потоки сообщений отключены", http.StatusForbidden)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(StreamsToken)) != 1 {
+		http.Error(w, "Неверный токен доступа", http.StatusUnauthorized)
+		log.Printf("Отклонено подключение к %s: %s", r.URL.Path, clientIP(r))
+		return false
+	}
+	return true
+}
+
+// wsClient - Подписчик WebSocket с собственным буфером отправки
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan []byte
+	sender    string // Фильтр по отправителю (пусто - все)
+	recipient string // Фильтр по получателю (пусто - все)
+
+	closeCode int    // Код закрытия, передаваемый клиенту после закрытия send
+	closeText string // Причина закрытия
+}
+
+// matches проверяет, подходит ли сообщение под фильтры подписчика
+func (c *wsClient) matches(message OutputMessage) bool {
+	return (c.sender == "" || c.sender == message.Sender) && (c.recipient == "" || c.recipient == message.Recipient)
+}
+
+// wsHub - Реестр подключенных подписчиков
+type wsHub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+}
+
+// Подписчики собранных сообщений
+var subscribers = &wsHub{clients: make(map[*wsClient]struct{})}
+
+// CloseSubscribers отключает подписчиков WebSocket; вызывается после доставки оставшихся сообщений
+func CloseSubscribers() {
+	subscribers.CloseAll()
+}
+
+func (h *wsHub) register(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+	wsMetrics.Add("clients", 1)
+}
+
+// unregister удаляет подписчика после разрыва соединения; повторный вызов ничего не делает
+func (h *wsHub) unregister(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnect(c, websocket.CloseNormalClosure, "")
+}
+
+// disconnect удаляет подписчика и закрывает его буфер; writePump передаст клиенту код закрытия.
+// Вызывается под h.mu.
+func (h *wsHub) disconnect(c *wsClient, code int, text string) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	c.closeCode, c.closeText = code, text
+	close(c.send)
+	wsMetrics.Add("clients", -1)
+}
+
+// CloseAll отключает всех подписчиков при остановке транспортного уровня
+func (h *wsHub) CloseAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		h.disconnect(c, websocket.CloseGoingAway, "транспортный уровень остановлен")
+	}
+}
+
+// Broadcast отправляет сообщение подходящим подписчикам без блокировки: подписчик,
+// чей буфер переполнен, отключается, чтобы не задерживать сборку и остальных.
+func (h *wsHub) Broadcast(message OutputMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Ошибка при маршалинге сообщения для WebSocket: %v", err)
+		return
+	}
+	for c := range h.clients {
+		if !c.matches(message) {
+			continue
+		}
+		select {
+		case c.send <- data:
+			wsMetrics.Add("sent", 1)
+		default:
+			log.Printf("Подписчик WebSocket %s не успевает получать сообщения и отключен", c.conn.RemoteAddr())
+			h.disconnect(c, websocket.ClosePolicyViolation, "буфер отправки переполнен")
+			wsMetrics.Add("dropped", 1)
+		}
+	}
+}
+
+// Count возвращает количество подключенных подписчиков
+func (h *wsHub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// HandleWebSocket подключает подписчика собранных сообщений.
+// Параметры запроса sender и recipient задают необязательные фильтры.
+func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !authorizeStream(w, r) {
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Ошибка установки WebSocket соединения: %v", err)
+		return // Upgrade уже ответил клиенту
+	}
+
+	client := &wsClient{
+		conn:      conn,
+		send:      make(chan []byte, WSSendBuffer),
+		sender:    r.URL.Query().Get("sender"),
+		recipient: r.URL.Query().Get("recipient"),
+	}
+	subscribers.register(client)
+	log.Printf("Подключен подписчик WebSocket %s (sender=%q, recipient=%q)", conn.RemoteAddr(), client.sender, client.recipient)
+
+	go client.writePump()
+	client.readPump()
+}
+
+// readPump читает управляющие кадры (pong, close) до разрыва соединения
+func (c *wsClient) readPump() {
+	defer func() {
+		subscribers.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxReadMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Ошибка чтения WebSocket %s: %v", c.conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// writePump отправляет сообщения из буфера и ping; при закрытии буфера завершает соединение
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				// Подписчик отключен: переполнение буфера, остановка или разрыв соединения
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// withStreamsToken задает токен /ws и /events на время теста
+func withStreamsToken(t *testing.T, token string) {
+	t.Helper()
+	saved := StreamsToken
+	t.Cleanup(func() { StreamsToken = saved })
+	StreamsToken = token
+}
+
+func TestWebSocketSubscription(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleWebSocket))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	// Без настроенного токена поток отключен, с неверным токеном подключение отклоняется
+	for _, c := range []struct {
+		serverToken, token string
+		status             int
+	}{
+		{"", "", http.StatusForbidden},
+		{"s3cret", "wrong", http.StatusUnauthorized},
+	} {
+		withStreamsToken(t, c.serverToken)
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?token="+c.token, nil)
+		if err == nil || resp == nil || resp.StatusCode != c.status {
+			t.Fatalf("токен %q при %q: %v, ожидался статус %d", c.token, c.serverToken, err, c.status)
+		}
+	}
+
+	withStreamsToken(t, "s3cret")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?recipient=bob&token=s3cret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Подписчик регистрируется после ответа на upgrade, поэтому ждем регистрации
+	deadline := time.Now().Add(2 * time.Second)
+	for subscribers.Count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sendTime := time.Date(2024, 5, 21, 2, 34, 48, 0, time.UTC)
+	subscribers.Broadcast(OutputMessage{Sender: "alice", SendTime: sendTime, Recipient: "carol", Payload: "не для bob"})
+	subscribers.Broadcast(OutputMessage{Sender: "alice", SendTime: sendTime, Recipient: "bob", Payload: "для bob"})
+
+	var got OutputMessage
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Payload != "для bob" || !got.SendTime.Equal(sendTime) {
+		t.Errorf("получено %+v", got)
+	}
+
+	subscribers.CloseAll()
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("ожидалось закрытие с CloseGoingAway, получено %v", err)
+	}
+}
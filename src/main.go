@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -15,7 +17,50 @@ import (
 )
 
 func main() {
+	// Подкоманды; без аргументов запускается сервер транспортного уровня
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "serve":
+			runServer()
+			return
+		case "dlq":
+			err = app.RunDLQCommand(os.Args[2:])
+		case "channel-sim":
+			err = app.RunChannelSim(os.Args[2:])
+		case "appl-stub":
+			err = app.RunApplStub(os.Args[2:])
+		case "bench":
+			err = app.RunBench(os.Args[2:])
+		case "send":
+			err = app.RunSendCommand(os.Args[2:])
+		case "transfer":
+			err = app.RunTransferCommand(os.Args[2:])
+		case "tail":
+			err = app.RunTailCommand(os.Args[2:])
+		case "inspect":
+			err = app.RunInspectCommand(os.Args[2:])
+		default:
+			err = fmt.Errorf("неизвестная команда: %s", os.Args[1])
+		}
+		if err != nil {
+			log.Fatalf("Ошибка выполнения команды %s: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	runServer()
+}
+
+// runServer запускает HTTP сервер и горутину сборки сегментов до получения сигнала завершения
+func runServer() {
 	log.Println("Запуск приложения...")
+	if err := app.CheckHTTPClient(); err != nil {
+		log.Fatalf("Ошибка настройки HTTP клиента: %v", err)
+	}
+	if err := app.CheckChannelPool(); err != nil {
+		log.Fatalf("Ошибка настройки канального уровня: %v", err)
+	}
 
 	// Контекст для graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -44,10 +89,27 @@ func main() {
 		log.Println("Горутина сборки сегментов завершила работу.")
 	}()
 
+	// Активная проверка адресов канального уровня
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		app.RunChannelHealthChecks(ctx)
+	}()
+
 	// Настройка маршрутов и HTTP сервера
-	r := mux.NewRouter()
+	// Маршруты сопоставляются по закодированному пути: ключ сообщения (отправитель и время)
+	// может содержать "/", клиенты передают его в пути экранированным (url.PathEscape)
+	r := mux.NewRouter().UseEncodedPath()
 	r.HandleFunc("/send", app.HandleSend).Methods(http.MethodPost, http.MethodOptions)
 	r.HandleFunc("/transfer", app.HandleTransfer).Methods(http.MethodPost, http.MethodOptions)
+	app.RegisterAdminRoutes(r)
+	app.RegisterHealthRoutes(r)
+	app.RegisterSubscriptionRoutes(r)
+	r.HandleFunc("/ws", app.HandleWebSocket).Methods(http.MethodGet)
+	r.HandleFunc("/events", app.HandleEvents).Methods(http.MethodGet)
+	r.HandleFunc("/messages/{id}/status", app.HandleMessageStatus).Methods(http.MethodGet)
+	r.HandleFunc("/send/{id}", app.HandleSendStatus).Methods(http.MethodGet)
+	r.Handle("/debug/vars", expvar.Handler()).Methods(http.MethodGet)
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -58,6 +120,10 @@ func main() {
 		IdleTimeout:  120 * time.Second,
 	}
 
+	// Потоки /events не завершаются сами; соединения WebSocket перехвачены (hijack) и не задерживают
+	// Shutdown, их подписчики отключаются после доставки оставшихся сообщений (CloseSubscribers)
+	srv.RegisterOnShutdown(app.CloseEventStreams)
+
 	// Запуск HTTP сервера в горутине
 	wg.Add(1)
 	go func() {
@@ -78,13 +144,18 @@ func main() {
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("Ошибка штатного завершения сервера: %v", err)
+		// Остальные компоненты все равно завершаются штатно
+		log.Printf("Ошибка штатного завершения HTTP сервера: %v", err)
+	} else {
+		log.Println("HTTP сервер штатно завершен.")
 	}
-	log.Println("HTTP сервер штатно завершен.")
+	app.StopSendJobs()
 
 	// Ожидаем завершения всех горутин
 	log.Println("Ожидание завершения всех горутин...")
 	wg.Wait()
+	app.CloseSinks()
+	app.CloseSubscribers()
 
 	log.Println("Приложение успешно завершено.")
 }